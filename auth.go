@@ -0,0 +1,290 @@
+package main
+
+import (
+	"context"
+	"crypto"
+	"crypto/hmac"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// APIKeyHeader is the header carrying a static API key.
+const APIKeyHeader = "X-API-Key"
+
+// ForwardedPrincipalHeader carries the principal of a request forwarded by a node, it is only trusted along with the node API key.
+const ForwardedPrincipalHeader = "X-Forwarded-Principal"
+
+var (
+	// ErrUnauthenticated is returned when a request carries no credentials.
+	ErrUnauthenticated = errors.New("unauthenticated")
+
+	// ErrInvalidCredentials is returned when the credentials of a request are rejected.
+	ErrInvalidCredentials = errors.New("invalid credentials")
+)
+
+// AuthConfiguration enables the authentication of API requests.
+//
+// A request is authenticated by an API key in the X-API-Key header, by a HS256 or RS256 JWT in
+// the Authorization header, or by the common name of a verified client certificate. Nodes call
+// each other with node_api_key, which is required. The role of a principal is given by its API
+// key or the role claim of its JWT, default_role otherwise.
+type AuthConfiguration struct {
+	APIKeys          map[string]*Principal `json:"api_keys"`
+	JWTSecret        string                `json:"jwt_secret"`
+	JWTPublicKeyFile string                `json:"jwt_public_key_file"`
+	JWTIssuer        string                `json:"jwt_issuer"`
+	NodeAPIKey       string                `json:"node_api_key"`
+	DefaultRole      Role                  `json:"default_role"`
+}
+
+// NodePrincipal is the principal of the requests made by nodes with the node API key.
+const NodePrincipal = "node"
+
+// Principal is the identity an API request is made on behalf of.
+type Principal struct {
+	Name string `json:"name"`
+	Role Role   `json:"role"`
+}
+
+// Authenticator checks the credentials of API requests.
+type Authenticator struct {
+	Config    *AuthConfiguration
+	publicKey *rsa.PublicKey
+}
+
+type principalKey struct{}
+
+// NewAuthenticator creates an authenticator, loading the RS256 public key when configured.
+func NewAuthenticator(config *AuthConfiguration) (*Authenticator, error) {
+	// Without it nodes would call each other as their client certificate, with default_role.
+	if config.NodeAPIKey == "" {
+		return nil, errors.New("auth requires node_api_key")
+	}
+
+	if config.DefaultRole == "" {
+		config.DefaultRole = ViewerRole
+	}
+
+	a := &Authenticator{
+		Config: config,
+	}
+
+	if config.JWTPublicKeyFile == "" {
+		return a, nil
+	}
+
+	data, err := os.ReadFile(config.JWTPublicKeyFile)
+	if err != nil {
+		return nil, err
+	}
+
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, errors.New("no PEM block found in JWT public key file")
+	}
+
+	key, err := x509.ParsePKIXPublicKey(block.Bytes)
+	if err != nil {
+		return nil, err
+	}
+
+	var ok bool
+	if a.publicKey, ok = key.(*rsa.PublicKey); !ok {
+		return nil, errors.New("JWT public key is not a RSA key")
+	}
+
+	return a, nil
+}
+
+// Authenticate finds the principal of a request.
+func (a *Authenticator) Authenticate(r *http.Request) (*Principal, error) {
+	if key := r.Header.Get(APIKeyHeader); key != "" {
+		principal, node, err := a.authenticateAPIKey(key)
+		if err != nil {
+			return nil, err
+		}
+
+		// Only the other nodes, which own the node key, may act on behalf of a principal.
+		if forwarded := r.Header.Get(ForwardedPrincipalHeader); forwarded != "" && node {
+			return decodePrincipal(forwarded)
+		}
+
+		return principal, nil
+	}
+
+	if authorization := r.Header.Get("Authorization"); authorization != "" {
+		token := strings.TrimPrefix(authorization, "Bearer ")
+		if token == authorization {
+			return nil, fmt.Errorf("%w: unsupported authorization scheme", ErrInvalidCredentials)
+		}
+
+		return a.authenticateJWT(token, time.Now())
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) > 0 {
+		return &Principal{
+			Name: r.TLS.VerifiedChains[0][0].Subject.CommonName,
+			Role: a.Config.DefaultRole,
+		}, nil
+	}
+
+	return nil, ErrUnauthenticated
+}
+
+// authenticateAPIKey finds the principal of an API key, node is true when the key is the node key.
+func (a *Authenticator) authenticateAPIKey(key string) (*Principal, bool, error) {
+	if a.Config.NodeAPIKey != "" && subtle.ConstantTimeCompare([]byte(key), []byte(a.Config.NodeAPIKey)) == 1 {
+		return &Principal{
+			Name: NodePrincipal,
+			Role: AdminRole,
+		}, true, nil
+	}
+
+	for k, principal := range a.Config.APIKeys {
+		if subtle.ConstantTimeCompare([]byte(key), []byte(k)) == 1 {
+			return a.withDefaultRole(principal), false, nil
+		}
+	}
+
+	return nil, false, fmt.Errorf("%w: unknown API key", ErrInvalidCredentials)
+}
+
+// jwtHeader is the header of a JWT.
+type jwtHeader struct {
+	Alg string `json:"alg"`
+}
+
+// jwtClaims are the claims of a JWT used to authenticate requests.
+type jwtClaims struct {
+	Subject   string `json:"sub"`
+	Issuer    string `json:"iss"`
+	ExpiresAt int64  `json:"exp"`
+	NotBefore int64  `json:"nbf"`
+	Role      Role   `json:"role"`
+}
+
+func (a *Authenticator) authenticateJWT(token string, now time.Time) (*Principal, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed JWT", ErrInvalidCredentials)
+	}
+
+	header := jwtHeader{}
+	if err := decodeJWTPart(parts[0], &header); err != nil {
+		return nil, err
+	}
+
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+
+	signed := []byte(parts[0] + "." + parts[1])
+	digest := sha256.Sum256(signed)
+
+	switch {
+	case header.Alg == "HS256" && a.Config.JWTSecret != "":
+		mac := hmac.New(sha256.New, []byte(a.Config.JWTSecret))
+		mac.Write(signed)
+		if !hmac.Equal(signature, mac.Sum(nil)) {
+			return nil, fmt.Errorf("%w: bad JWT signature", ErrInvalidCredentials)
+		}
+	case header.Alg == "RS256" && a.publicKey != nil:
+		if err := rsa.VerifyPKCS1v15(a.publicKey, crypto.SHA256, digest[:], signature); err != nil {
+			return nil, fmt.Errorf("%w: bad JWT signature", ErrInvalidCredentials)
+		}
+	default:
+		return nil, fmt.Errorf("%w: unsupported JWT algorithm %q", ErrInvalidCredentials, header.Alg)
+	}
+
+	claims := jwtClaims{}
+	if err := decodeJWTPart(parts[1], &claims); err != nil {
+		return nil, err
+	}
+
+	if claims.ExpiresAt != 0 && now.Unix() >= claims.ExpiresAt {
+		return nil, fmt.Errorf("%w: JWT expired", ErrInvalidCredentials)
+	}
+
+	if claims.NotBefore != 0 && now.Unix() < claims.NotBefore {
+		return nil, fmt.Errorf("%w: JWT not valid yet", ErrInvalidCredentials)
+	}
+
+	if a.Config.JWTIssuer != "" && claims.Issuer != a.Config.JWTIssuer {
+		return nil, fmt.Errorf("%w: unexpected JWT issuer", ErrInvalidCredentials)
+	}
+
+	if claims.Subject == "" {
+		return nil, fmt.Errorf("%w: JWT without subject", ErrInvalidCredentials)
+	}
+
+	return a.withDefaultRole(&Principal{
+		Name: claims.Subject,
+		Role: claims.Role,
+	}), nil
+}
+
+func (a *Authenticator) withDefaultRole(principal *Principal) *Principal {
+	if principal.Role != "" {
+		return principal
+	}
+
+	return &Principal{
+		Name: principal.Name,
+		Role: a.Config.DefaultRole,
+	}
+}
+
+// EncodePrincipal encodes a principal into a header value.
+func EncodePrincipal(principal *Principal) (string, error) {
+	data, err := json.Marshal(principal)
+	if err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(data), nil
+}
+
+func decodePrincipal(value string) (*Principal, error) {
+	principal := Principal{}
+	if err := decodeJWTPart(value, &principal); err != nil {
+		return nil, err
+	}
+
+	return &principal, nil
+}
+
+func decodeJWTPart(part string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(part)
+	if err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("%w: %s", ErrInvalidCredentials, err)
+	}
+
+	return nil
+}
+
+// WithPrincipal returns a copy of ctx carrying the principal.
+func WithPrincipal(ctx context.Context, principal *Principal) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// PrincipalFrom returns the principal carried by ctx, nil when the request was not authenticated.
+func PrincipalFrom(ctx context.Context) *Principal {
+	principal, _ := ctx.Value(principalKey{}).(*Principal)
+	return principal
+}
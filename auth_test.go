@@ -0,0 +1,187 @@
+package main
+
+import (
+	"crypto"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+const testJWTSecret = "hs256-secret"
+
+// newTestAuthenticator creates an authenticator accepting HS256 tokens when secret is set and
+// RS256 tokens signed by the returned key.
+func newTestAuthenticator(t *testing.T, secret string) (*Authenticator, *rsa.PrivateKey) {
+	t.Helper()
+
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	file := filepath.Join(t.TempDir(), "jwt.pub")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	a, err := NewAuthenticator(&AuthConfiguration{
+		APIKeys: map[string]*Principal{
+			"alice-key": {Name: "alice", Role: BuilderRole},
+		},
+		JWTSecret:        secret,
+		JWTPublicKeyFile: file,
+		JWTIssuer:        "bikeme",
+		NodeAPIKey:       "node-key",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return a, key
+}
+
+// signJWT signs claims with the HS256 secret, the RS256 key or not at all for the none algorithm.
+func signJWT(t *testing.T, alg string, claims *jwtClaims, key *rsa.PrivateKey) string {
+	t.Helper()
+
+	encode := func(v interface{}) string {
+		data, err := json.Marshal(v)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		return base64.RawURLEncoding.EncodeToString(data)
+	}
+
+	signed := encode(&jwtHeader{Alg: alg}) + "." + encode(claims)
+
+	var signature []byte
+	switch alg {
+	case "HS256":
+		mac := hmac.New(sha256.New, []byte(testJWTSecret))
+		mac.Write([]byte(signed))
+		signature = mac.Sum(nil)
+	case "RS256":
+		digest := sha256.Sum256([]byte(signed))
+
+		var err error
+		if signature, err = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:]); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestAuthenticateJWT(t *testing.T) {
+	a, key := newTestAuthenticator(t, testJWTSecret)
+	rsaOnly, _ := newTestAuthenticator(t, "")
+	_, otherKey := newTestAuthenticator(t, "")
+
+	now := time.Now()
+
+	valid := func() *jwtClaims {
+		return &jwtClaims{
+			Subject:   "bob",
+			Issuer:    "bikeme",
+			ExpiresAt: now.Add(time.Hour).Unix(),
+			NotBefore: now.Add(-time.Hour).Unix(),
+			Role:      AdminRole,
+		}
+	}
+
+	tamper := func(token string) string {
+		return token[:len(token)-4] + "AAAA"
+	}
+
+	for _, test := range []struct {
+		name          string
+		authenticator *Authenticator
+		token         string
+		err           bool
+	}{
+		{"HS256", a, signJWT(t, "HS256", valid(), nil), false},
+		{"RS256", a, signJWT(t, "RS256", valid(), key), false},
+		{"HS256 bad signature", a, tamper(signJWT(t, "HS256", valid(), nil)), true},
+		{"RS256 bad signature", a, tamper(signJWT(t, "RS256", valid(), key)), true},
+		{"RS256 other key", a, signJWT(t, "RS256", valid(), otherKey), true},
+		{"none", a, signJWT(t, "none", valid(), nil), true},
+		{"HS256 without secret", rsaOnly, signJWT(t, "HS256", valid(), nil), true},
+		{"expired", a, signJWT(t, "HS256", &jwtClaims{Subject: "bob", Issuer: "bikeme", ExpiresAt: now.Unix()}, nil), true},
+		{"not valid yet", a, signJWT(t, "HS256", &jwtClaims{Subject: "bob", Issuer: "bikeme", NotBefore: now.Add(time.Minute).Unix()}, nil), true},
+		{"wrong issuer", a, signJWT(t, "RS256", &jwtClaims{Subject: "bob", Issuer: "other"}, key), true},
+		{"no subject", a, signJWT(t, "HS256", &jwtClaims{Issuer: "bikeme"}, nil), true},
+		{"malformed", a, "not.a-jwt", true},
+	} {
+		principal, err := test.authenticator.authenticateJWT(test.token, now)
+
+		if test.err {
+			if !errors.Is(err, ErrInvalidCredentials) {
+				t.Errorf("%s: err=%v, want %v", test.name, err, ErrInvalidCredentials)
+			}
+
+			continue
+		}
+
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if principal.Name != "bob" || principal.Role != AdminRole {
+			t.Errorf("%s: principal=%+v, want bob with the admin role", test.name, principal)
+		}
+	}
+}
+
+func TestAuthenticateForwardedPrincipal(t *testing.T) {
+	a, _ := newTestAuthenticator(t, testJWTSecret)
+
+	forwarded, err := EncodePrincipal(&Principal{Name: "carol", Role: AdminRole})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, test := range []struct {
+		name      string
+		key       string
+		forwarded string
+		principal Principal
+	}{
+		{"node key", "node-key", forwarded, Principal{Name: "carol", Role: AdminRole}},
+		{"node key alone", "node-key", "", Principal{Name: NodePrincipal, Role: AdminRole}},
+		{"API key", "alice-key", forwarded, Principal{Name: "alice", Role: BuilderRole}},
+	} {
+		r := httptest.NewRequest("POST", "/bikes", nil)
+		r.Header.Set(APIKeyHeader, test.key)
+		if test.forwarded != "" {
+			r.Header.Set(ForwardedPrincipalHeader, test.forwarded)
+		}
+
+		principal, err := a.Authenticate(r)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		} else if *principal != test.principal {
+			t.Errorf("%s: principal=%+v, want %+v", test.name, principal, test.principal)
+		}
+	}
+}
+
+func TestNewAuthenticatorRequiresNodeAPIKey(t *testing.T) {
+	if _, err := NewAuthenticator(&AuthConfiguration{JWTSecret: testJWTSecret}); err == nil {
+		t.Fatal("authenticator created without node_api_key")
+	}
+}
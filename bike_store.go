@@ -2,6 +2,7 @@ package main
 
 import (
 	"database/sql"
+	"encoding/json"
 	"fmt"
 	"strings"
 
@@ -15,16 +16,114 @@ type BikeStore struct {
 
 // Bike is used to store bikes in database.
 type Bike struct {
-	ID         uint64       `json:"id"`
-	Name       string       `json:"name"`
-	Components []*Component `json:"components"`
+	ID            uint64       `json:"id"`
+	Name          string       `json:"name"`
+	Owner         string       `json:"owner,omitempty"`
+	Garage        string       `json:"garage,omitempty"`
+	Components    []*Component `json:"components"`
+	Summary       *Summary     `json:"summary,omitempty"`
+	Compatibility []*Issue     `json:"compatibility,omitempty"`
+	Gearing       *Gearing     `json:"gearing,omitempty"`
 }
 
-// Component is a part of a bike.
+// Summary is computed from the components of a bike, a bike is rideable when no required category is missing.
+type Summary struct {
+	Weight   uint64   `json:"weight"`
+	Price    uint64   `json:"price"`
+	Missing  []string `json:"missing"`
+	Rideable bool     `json:"rideable"`
+}
+
+// Component is a part of a bike, weight is in grams and price in minor currency units.
+// When the component references a catalog part, its attributes are read from the catalog.
 type Component struct {
-	ID     uint64 `json:"id"`
-	BikeID uint64 `json:"bike_id"`
-	Name   string `json:"name"`
+	ID        uint64            `json:"id"`
+	BikeID    uint64            `json:"bike_id"`
+	CatalogID uint64            `json:"catalog_id,omitempty"`
+	Name      string            `json:"name"`
+	Category  string            `json:"category"`
+	Brand     string            `json:"brand"`
+	Model     string            `json:"model"`
+	Weight    uint64            `json:"weight"`
+	Price     uint64            `json:"price"`
+	Specs     map[string]string `json:"specs,omitempty"`
+}
+
+// Categories lists the known component categories.
+var Categories = []string{
+	"frame",
+	"fork",
+	"wheelset",
+	"tires",
+	"drivetrain",
+	"brakes",
+	"cockpit",
+	"seatpost",
+	"saddle",
+	"pedals",
+	"accessories",
+}
+
+// RequiredCategories lists the categories a bike needs to be rideable.
+var RequiredCategories = []string{
+	"frame",
+	"fork",
+	"wheelset",
+	"drivetrain",
+	"brakes",
+	"cockpit",
+	"saddle",
+}
+
+const componentColumns = `component.rowid, component.bike_rowid, component.catalog_rowid, component.name, component.category,
+	component.brand, component.model, component.weight, component.price, component.specs,
+	catalog.rowid, catalog.name, catalog.category, catalog.brand, catalog.model, catalog.weight, catalog.price, catalog.specs`
+
+const componentJoin = "component LEFT JOIN catalog ON catalog.rowid = component.catalog_rowid"
+
+// migrations are applied in order to databases whose user_version is lower than their position.
+//
+// The bike, component and catalog tables have an INTEGER PRIMARY KEY id, which rowid is an alias of,
+// so that IDs replicated across nodes are never renumbered by SQLite, e.g. on VACUUM.
+var migrations = []string{
+	`ALTER TABLE component ADD COLUMN category TEXT NOT NULL DEFAULT '';
+	ALTER TABLE component ADD COLUMN brand TEXT NOT NULL DEFAULT '';
+	ALTER TABLE component ADD COLUMN model TEXT NOT NULL DEFAULT '';
+	ALTER TABLE component ADD COLUMN weight INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE component ADD COLUMN price INTEGER NOT NULL DEFAULT 0;
+	ALTER TABLE component ADD COLUMN specs TEXT NOT NULL DEFAULT ''`,
+	`CREATE TABLE IF NOT EXISTS catalog(name TEXT NOT NULL, category TEXT NOT NULL DEFAULT '', brand TEXT NOT NULL DEFAULT '', model TEXT NOT NULL DEFAULT '',
+		weight INTEGER NOT NULL DEFAULT 0, price INTEGER NOT NULL DEFAULT 0, specs TEXT NOT NULL DEFAULT '');
+	ALTER TABLE component ADD COLUMN catalog_rowid INTEGER NOT NULL DEFAULT 0;
+	CREATE INDEX IF NOT EXISTS component_catalog_rowid_idx ON component(catalog_rowid)`,
+	`CREATE TABLE IF NOT EXISTS counter(name TEXT NOT NULL PRIMARY KEY, value INTEGER NOT NULL);
+	INSERT INTO counter(name, value) SELECT 'bike', COALESCE(MAX(rowid), 0) FROM bike;
+	INSERT INTO counter(name, value) SELECT 'component', COALESCE(MAX(rowid), 0) FROM component;
+	INSERT INTO counter(name, value) SELECT 'catalog', COALESCE(MAX(rowid), 0) FROM catalog;
+	INSERT INTO counter(name, value) VALUES('applied_index', 0)`,
+	`CREATE TABLE IF NOT EXISTS node(id TEXT NOT NULL PRIMARY KEY, api_address TEXT NOT NULL DEFAULT '')`,
+	`ALTER TABLE bike ADD COLUMN owner TEXT NOT NULL DEFAULT ''`,
+	`ALTER TABLE bike ADD COLUMN garage TEXT NOT NULL DEFAULT 'default';
+	CREATE INDEX IF NOT EXISTS bike_garage_idx ON bike(garage);
+	CREATE TABLE IF NOT EXISTS garage(name TEXT NOT NULL PRIMARY KEY, max_bikes INTEGER NOT NULL DEFAULT 0)`,
+	`CREATE TABLE bike_new(id INTEGER PRIMARY KEY, name TEXT NOT NULL, owner TEXT NOT NULL DEFAULT '', garage TEXT NOT NULL DEFAULT 'default');
+	INSERT INTO bike_new(id, name, owner, garage) SELECT rowid, name, owner, garage FROM bike;
+	DROP TABLE bike;
+	ALTER TABLE bike_new RENAME TO bike;
+	CREATE INDEX IF NOT EXISTS bike_garage_idx ON bike(garage);
+	CREATE TABLE component_new(id INTEGER PRIMARY KEY, bike_rowid INTEGER, name TEXT NOT NULL, category TEXT NOT NULL DEFAULT '', brand TEXT NOT NULL DEFAULT '',
+		model TEXT NOT NULL DEFAULT '', weight INTEGER NOT NULL DEFAULT 0, price INTEGER NOT NULL DEFAULT 0, specs TEXT NOT NULL DEFAULT '', catalog_rowid INTEGER NOT NULL DEFAULT 0);
+	INSERT INTO component_new(id, bike_rowid, name, category, brand, model, weight, price, specs, catalog_rowid)
+		SELECT rowid, bike_rowid, name, category, brand, model, weight, price, specs, catalog_rowid FROM component;
+	DROP TABLE component;
+	ALTER TABLE component_new RENAME TO component;
+	CREATE INDEX IF NOT EXISTS component_bike_rowid_idx ON component(bike_rowid);
+	CREATE INDEX IF NOT EXISTS component_catalog_rowid_idx ON component(catalog_rowid);
+	CREATE TABLE catalog_new(id INTEGER PRIMARY KEY, name TEXT NOT NULL, category TEXT NOT NULL DEFAULT '', brand TEXT NOT NULL DEFAULT '', model TEXT NOT NULL DEFAULT '',
+		weight INTEGER NOT NULL DEFAULT 0, price INTEGER NOT NULL DEFAULT 0, specs TEXT NOT NULL DEFAULT '');
+	INSERT INTO catalog_new(id, name, category, brand, model, weight, price, specs) SELECT rowid, name, category, brand, model, weight, price, specs FROM catalog;
+	DROP TABLE catalog;
+	ALTER TABLE catalog_new RENAME TO catalog`,
 }
 
 // NewBikeStore creates a database.
@@ -34,6 +133,11 @@ func NewBikeStore(path string) (*BikeStore, error) {
 		return nil, err
 	}
 
+	// Readers do not block the writer in WAL mode, snapshots are read while logs are applied.
+	if _, err = db.Exec("PRAGMA journal_mode=WAL"); err != nil {
+		return nil, err
+	}
+
 	if _, err = db.Exec("CREATE TABLE IF NOT EXISTS bike(name TEXT NOT NULL)"); err != nil {
 		return nil, err
 	}
@@ -46,14 +150,32 @@ func NewBikeStore(path string) (*BikeStore, error) {
 		return nil, err
 	}
 
+	if err := migrate(db); err != nil {
+		return nil, err
+	}
+
 	return &BikeStore{
 		DB: db,
 	}, nil
 }
 
-// GetBikes selects bikes from database.
-func (bs *BikeStore) GetBikes(limit, offset uint64, bikes *[]*Bike) error {
-	rows, err := bs.DB.Query("SELECT rowid, name FROM bike ORDER BY rowid DESC LIMIT ? OFFSET ?", limit, offset)
+// GetOwner gets the owner of a bike from database.
+func (bs *BikeStore) GetOwner(id uint64) (string, error) {
+	owner := ""
+	if err := bs.DB.QueryRow("SELECT owner FROM bike WHERE rowid = ?", id).Scan(&owner); err != nil {
+		return "", err
+	}
+
+	return owner, nil
+}
+
+// GetBikes selects the bikes of a garage from database, bikes of every garage are selected when garage is empty.
+func (bs *BikeStore) GetBikes(garage string, limit, offset uint64, bikes *[]*Bike) error {
+	return getBikes(bs.DB, bikes, "SELECT rowid, name, owner, garage FROM bike WHERE ? = '' OR garage = ? ORDER BY rowid DESC LIMIT ? OFFSET ?", garage, garage, limit, offset)
+}
+
+func getBikes(q queryer, bikes *[]*Bike, query string, args ...interface{}) error {
+	rows, err := q.Query(query, args...)
 	if err != nil {
 		return err
 	}
@@ -63,7 +185,7 @@ func (bs *BikeStore) GetBikes(limit, offset uint64, bikes *[]*Bike) error {
 	for rows.Next() {
 		b := Bike{}
 
-		if err := rows.Scan(&b.ID, &b.Name); err != nil {
+		if err := rows.Scan(&b.ID, &b.Name, &b.Owner, &b.Garage); err != nil {
 			return err
 		}
 
@@ -72,7 +194,7 @@ func (bs *BikeStore) GetBikes(limit, offset uint64, bikes *[]*Bike) error {
 		*bikes = append(*bikes, &b)
 	}
 
-	rows, err = bs.DB.Query(fmt.Sprintf("SELECT rowid, bike_rowid, name FROM component WHERE bike_rowid IN (%s)", strings.Join(bikeIDs, ",")))
+	rows, err = q.Query(fmt.Sprintf("SELECT %s FROM %s WHERE component.bike_rowid IN (%s)", componentColumns, componentJoin, strings.Join(bikeIDs, ",")))
 	if err != nil {
 		return err
 	}
@@ -82,7 +204,7 @@ func (bs *BikeStore) GetBikes(limit, offset uint64, bikes *[]*Bike) error {
 	for rows.Next() {
 		component := Component{}
 
-		if err := rows.Scan(&component.ID, &component.BikeID, &component.Name); err != nil {
+		if err := scanComponent(rows, &component); err != nil {
 			return err
 		}
 
@@ -102,11 +224,11 @@ func (bs *BikeStore) GetBikes(limit, offset uint64, bikes *[]*Bike) error {
 
 // GetBike get a bike from database.
 func (bs *BikeStore) GetBike(id uint64, bike *Bike) error {
-	if err := bs.DB.QueryRow("SELECT rowid, name FROM bike WHERE rowid = ?", id).Scan(&bike.ID, &bike.Name); err != nil {
+	if err := bs.DB.QueryRow("SELECT rowid, name, owner, garage FROM bike WHERE rowid = ?", id).Scan(&bike.ID, &bike.Name, &bike.Owner, &bike.Garage); err != nil {
 		return err
 	}
 
-	rows, err := bs.DB.Query("SELECT rowid, bike_rowid, name FROM component WHERE bike_rowid = ?", id)
+	rows, err := bs.DB.Query(fmt.Sprintf("SELECT %s FROM %s WHERE component.bike_rowid = ?", componentColumns, componentJoin), id)
 	if err != nil {
 		return err
 	}
@@ -115,7 +237,7 @@ func (bs *BikeStore) GetBike(id uint64, bike *Bike) error {
 	for rows.Next() {
 		component := Component{}
 
-		if err := rows.Scan(&component.ID, &component.BikeID, &component.Name); err != nil {
+		if err := scanComponent(rows, &component); err != nil {
 			return err
 		}
 
@@ -125,77 +247,194 @@ func (bs *BikeStore) GetBike(id uint64, bike *Bike) error {
 	return nil
 }
 
-// StoreBike inserts a bike into the database.
-func (bs *BikeStore) StoreBike(bike *Bike) error {
-	return bs.StoreBikes([]*Bike{bike})
+// GetSummary computes the summary of a bike from database.
+func (bs *BikeStore) GetSummary(id uint64, summary *Summary) error {
+	bike := Bike{}
+	if err := bs.GetBike(id, &bike); err != nil {
+		return err
+	}
+
+	*summary = *NewSummary(bike.Components)
+
+	return nil
+}
+
+// NewSummary computes the summary of already loaded components.
+func NewSummary(components []*Component) *Summary {
+	summary := Summary{}
+
+	categories := map[string]bool{}
+	for _, component := range components {
+		summary.Weight += component.Weight
+		summary.Price += component.Price
+
+		categories[component.Category] = true
+	}
+
+	summary.Missing = missingCategories(categories)
+	summary.Rideable = len(summary.Missing) == 0
+
+	return &summary
 }
 
-// StoreBikes inserts some bikes into the database.
-func (bs *BikeStore) StoreBikes(bikes []*Bike) error {
+// DeleteRange deletes some bikes.
+func (bs *BikeStore) DeleteRange(min, max uint64) error {
 	tx, err := bs.DB.Begin()
 	if err != nil {
 		return err
 	}
 
-	stmt, err := tx.Prepare("INSERT INTO bike(name) VALUES(?)")
-	if err != nil {
+	if _, err := bs.DB.Exec("DELETE FROM bike WHERE rowid BETWEEN ? AND ?", min, max); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if _, err := bs.DB.Exec("DELETE FROM component WHERE bike_rowid BETWEEN ? AND ?", min, max); err != nil {
 		tx.Rollback()
 		return err
 	}
 
-	for _, bike := range bikes {
-		if _, err := stmt.Exec(bike.Name); err != nil {
+	return tx.Commit()
+}
+
+// ClearComponentIDs resets the IDs of the components of a bike so that new ones are assigned.
+func (b *Bike) ClearComponentIDs() {
+	for _, component := range b.Components {
+		component.ID = 0
+	}
+}
+
+// Validate checks the components of a bike.
+func (b *Bike) Validate() error {
+	for _, component := range b.Components {
+		if err := component.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that the category of a component is known, an empty category is allowed.
+func (c *Component) Validate() error {
+	if c.Category == "" {
+		return nil
+	}
+
+	for _, category := range Categories {
+		if c.Category == category {
+			return nil
+		}
+	}
+
+	return fmt.Errorf("unknown component category %q", c.Category)
+}
+
+func missingCategories(categories map[string]bool) []string {
+	missing := []string{}
+	for _, category := range RequiredCategories {
+		if !categories[category] {
+			missing = append(missing, category)
+		}
+	}
+
+	return missing
+}
+
+func migrate(db *sql.DB) error {
+	version := 0
+	if err := db.QueryRow("PRAGMA user_version").Scan(&version); err != nil {
+		return err
+	}
+
+	for ; version < len(migrations); version++ {
+		tx, err := db.Begin()
+		if err != nil {
+			return err
+		}
+
+		if _, err := tx.Exec(migrations[version]); err != nil {
 			tx.Rollback()
 			return err
 		}
 
-		if err := tx.QueryRow("SELECT last_insert_rowid()").Scan(&bike.ID); err != nil {
+		if _, err := tx.Exec(fmt.Sprintf("PRAGMA user_version = %d", version+1)); err != nil {
 			tx.Rollback()
 			return err
 		}
+
+		if err := tx.Commit(); err != nil {
+			return err
+		}
 	}
 
-	stmt, err = tx.Prepare("INSERT INTO component(bike_rowid, name) VALUES(?, ?)")
-	if err != nil {
-		tx.Rollback()
+	return nil
+}
+
+func scanComponent(rows *sql.Rows, component *Component) error {
+	specs := ""
+	part := struct {
+		ID       sql.NullInt64
+		Name     sql.NullString
+		Category sql.NullString
+		Brand    sql.NullString
+		Model    sql.NullString
+		Weight   sql.NullInt64
+		Price    sql.NullInt64
+		Specs    sql.NullString
+	}{}
+
+	if err := rows.Scan(&component.ID, &component.BikeID, &component.CatalogID, &component.Name, &component.Category, &component.Brand, &component.Model, &component.Weight, &component.Price, &specs,
+		&part.ID, &part.Name, &part.Category, &part.Brand, &part.Model, &part.Weight, &part.Price, &part.Specs); err != nil {
 		return err
 	}
 
-	for _, bike := range bikes {
-		for _, component := range bike.Components {
-			component.BikeID = bike.ID
+	if part.ID.Valid {
+		if component.Name == "" {
+			component.Name = part.Name.String
+		}
 
-			if _, err := stmt.Exec(component.BikeID, component.Name); err != nil {
-				tx.Rollback()
-				return err
-			}
+		component.Category = part.Category.String
+		component.Brand = part.Brand.String
+		component.Model = part.Model.String
+		component.Weight = uint64(part.Weight.Int64)
+		component.Price = uint64(part.Price.Int64)
+		specs = part.Specs.String
+	}
 
-			if err := tx.QueryRow("SELECT last_insert_rowid()").Scan(&component.ID); err != nil {
-				tx.Rollback()
-				return err
-			}
-		}
+	return decodeSpecs(specs, &component.Specs)
+}
+
+func decodeSpecs(specs string, v *map[string]string) error {
+	if specs == "" {
+		return nil
 	}
 
-	return tx.Commit()
+	return json.Unmarshal([]byte(specs), v)
 }
 
-// DeleteRange deletes some bikes.
-func (bs *BikeStore) DeleteRange(min, max uint64) error {
-	tx, err := bs.DB.Begin()
+func encodeSpecs(specs map[string]string) (string, error) {
+	if len(specs) == 0 {
+		return "", nil
+	}
+
+	data, err := json.Marshal(specs)
 	if err != nil {
-		return err
+		return "", err
 	}
 
-	if _, err := bs.DB.Exec("DELETE FROM bike WHERE rowid BETWEEN ? AND ?", min, max); err != nil {
-		tx.Rollback()
+	return string(data), nil
+}
+
+func checkAffected(res sql.Result) error {
+	n, err := res.RowsAffected()
+	if err != nil {
 		return err
 	}
 
-	if _, err := bs.DB.Exec("DELETE FROM component WHERE bike_rowid BETWEEN ? AND ?", min, max); err != nil {
-		tx.Rollback()
-		return err
+	if n == 0 {
+		return sql.ErrNoRows
 	}
 
-	return tx.Commit()
+	return nil
 }
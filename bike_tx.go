@@ -0,0 +1,275 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// Counters kept in the bike store, they are replicated through the Raft log and snapshots.
+//
+// IDs are assigned by the FSM from the bike, component and catalog counters which only grow:
+// an ID is never reused, even after the record is deleted, so deletes leave gaps. A failed
+// command rolls back its transaction and does not consume any ID. Records restored from a
+// snapshot keep their IDs and counters never go below the highest restored ID.
+const (
+	BikeCounter         = "bike"
+	ComponentCounter    = "component"
+	CatalogCounter      = "catalog"
+	AppliedIndexCounter = "applied_index"
+)
+
+// BikeTx is a write transaction on the bike store.
+type BikeTx struct {
+	tx *sql.Tx
+}
+
+// Update runs fn within a transaction recording index as the last applied Raft log index.
+func (bs *BikeStore) Update(index uint64, fn func(t *BikeTx) error) error {
+	tx, err := bs.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	t := &BikeTx{
+		tx: tx,
+	}
+
+	if err := fn(t); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	if err := t.SetCounter(AppliedIndexCounter, index); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// Replace atomically replaces the whole content of the database by what fn stores within the transaction.
+func (bs *BikeStore) Replace(fn func(t *BikeTx) error) error {
+	tx, err := bs.DB.Begin()
+	if err != nil {
+		return err
+	}
+
+	for _, query := range []string{
+		"DELETE FROM component",
+		"DELETE FROM bike",
+		"DELETE FROM catalog",
+		"DELETE FROM node",
+		"DELETE FROM garage",
+		"UPDATE counter SET value = 0",
+	} {
+		if _, err := tx.Exec(query); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if err := fn(&BikeTx{tx: tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+
+	return tx.Commit()
+}
+
+func getCounters(q queryer, counters map[string]uint64) error {
+	rows, err := q.Query("SELECT name, value FROM counter")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		name, value := "", uint64(0)
+
+		if err := rows.Scan(&name, &value); err != nil {
+			return err
+		}
+
+		counters[name] = value
+	}
+
+	return rows.Err()
+}
+
+// AppliedIndex reads the last applied Raft log index from database.
+func (bs *BikeStore) AppliedIndex() (uint64, error) {
+	index := uint64(0)
+	if err := bs.DB.QueryRow("SELECT value FROM counter WHERE name = ?", AppliedIndexCounter).Scan(&index); err != nil {
+		return 0, err
+	}
+
+	return index, nil
+}
+
+// SetCounter sets the value of a counter.
+func (t *BikeTx) SetCounter(name string, value uint64) error {
+	_, err := t.tx.Exec("INSERT OR REPLACE INTO counter(name, value) VALUES(?, ?)", name, value)
+	return err
+}
+
+// StoreBikes inserts some bikes into the database, the IDs of bikes and components are kept when set and bikes without garage go to the default one.
+func (t *BikeTx) StoreBikes(bikes []*Bike) error {
+	stmt, err := t.tx.Prepare("INSERT INTO bike(rowid, name, owner, garage) VALUES(?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, bike := range bikes {
+		if bike.ID, err = t.nextID(BikeCounter, bike.ID); err != nil {
+			return err
+		}
+
+		if bike.Garage == "" {
+			bike.Garage = DefaultGarage
+		}
+
+		if _, err := stmt.Exec(bike.ID, bike.Name, bike.Owner, bike.Garage); err != nil {
+			return err
+		}
+	}
+
+	for _, bike := range bikes {
+		if err := t.insertComponents(bike.ID, bike.Components); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// ReplaceBike replaces the name and the components of a bike, its owner and garage are kept.
+func (t *BikeTx) ReplaceBike(bike *Bike) error {
+	if err := t.RenameBike(bike.ID, bike.Name); err != nil {
+		return err
+	}
+
+	if err := t.tx.QueryRow("SELECT owner, garage FROM bike WHERE rowid = ?", bike.ID).Scan(&bike.Owner, &bike.Garage); err != nil {
+		return err
+	}
+
+	if _, err := t.tx.Exec("DELETE FROM component WHERE bike_rowid = ?", bike.ID); err != nil {
+		return err
+	}
+
+	return t.insertComponents(bike.ID, bike.Components)
+}
+
+// RenameBike changes the name of a bike.
+func (t *BikeTx) RenameBike(id uint64, name string) error {
+	res, err := t.tx.Exec("UPDATE bike SET name = ? WHERE rowid = ?", name, id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// DeleteBike deletes a bike and its components.
+func (t *BikeTx) DeleteBike(id uint64) error {
+	res, err := t.tx.Exec("DELETE FROM bike WHERE rowid = ?", id)
+	if err != nil {
+		return err
+	}
+
+	if err := checkAffected(res); err != nil {
+		return err
+	}
+
+	_, err = t.tx.Exec("DELETE FROM component WHERE bike_rowid = ?", id)
+	return err
+}
+
+// StoreComponent inserts a component into an existing bike.
+func (t *BikeTx) StoreComponent(component *Component) error {
+	bikeID := uint64(0)
+	if err := t.tx.QueryRow("SELECT rowid FROM bike WHERE rowid = ?", component.BikeID).Scan(&bikeID); err != nil {
+		return err
+	}
+
+	return t.insertComponents(bikeID, []*Component{component})
+}
+
+// UpdateComponent updates a component belonging to a bike.
+func (t *BikeTx) UpdateComponent(component *Component) error {
+	if err := checkCatalogPart(t.tx, component.CatalogID); err != nil {
+		return err
+	}
+
+	specs, err := encodeSpecs(component.Specs)
+	if err != nil {
+		return err
+	}
+
+	res, err := t.tx.Exec("UPDATE component SET catalog_rowid = ?, name = ?, category = ?, brand = ?, model = ?, weight = ?, price = ?, specs = ? WHERE rowid = ? AND bike_rowid = ?",
+		component.CatalogID, component.Name, component.Category, component.Brand, component.Model, component.Weight, component.Price, specs, component.ID, component.BikeID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// DeleteComponent deletes a component belonging to a bike.
+func (t *BikeTx) DeleteComponent(bikeID, id uint64) error {
+	res, err := t.tx.Exec("DELETE FROM component WHERE rowid = ? AND bike_rowid = ?", id, bikeID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+func (t *BikeTx) insertComponents(bikeID uint64, components []*Component) error {
+	stmt, err := t.tx.Prepare("INSERT INTO component(rowid, bike_rowid, catalog_rowid, name, category, brand, model, weight, price, specs) VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?)")
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, component := range components {
+		component.BikeID = bikeID
+
+		if err := checkCatalogPart(t.tx, component.CatalogID); err != nil {
+			return err
+		}
+
+		specs, err := encodeSpecs(component.Specs)
+		if err != nil {
+			return err
+		}
+
+		if component.ID, err = t.nextID(ComponentCounter, component.ID); err != nil {
+			return err
+		}
+
+		if _, err := stmt.Exec(component.ID, component.BikeID, component.CatalogID, component.Name, component.Category, component.Brand, component.Model, component.Weight, component.Price, specs); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// nextID increments a counter to assign a new ID, an already set ID is kept and only raises the counter.
+func (t *BikeTx) nextID(name string, id uint64) (uint64, error) {
+	if id != 0 {
+		_, err := t.tx.Exec("UPDATE counter SET value = MAX(value, ?) WHERE name = ?", id, name)
+		return id, err
+	}
+
+	if _, err := t.tx.Exec("UPDATE counter SET value = value + 1 WHERE name = ?", name); err != nil {
+		return 0, err
+	}
+
+	if err := t.tx.QueryRow("SELECT value FROM counter WHERE name = ?", name).Scan(&id); err != nil {
+		return 0, fmt.Errorf("counter %s: %w", name, err)
+	}
+
+	return id, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"database/sql"
+	"fmt"
+)
+
+// BikeView is a read transaction on the bike store, it sees the bike store as it was when the view was
+// opened whatever is written meanwhile.
+type BikeView struct {
+	Counters map[string]uint64
+	tx       *sql.Tx
+}
+
+// View opens a view of the bike store and reads its counters, the view must be released.
+func (bs *BikeStore) View() (*BikeView, error) {
+	tx, err := bs.DB.Begin()
+	if err != nil {
+		return nil, err
+	}
+
+	v := &BikeView{
+		Counters: map[string]uint64{},
+		tx:       tx,
+	}
+
+	// SQLite takes the snapshot of a transaction on its first read.
+	if err := getCounters(tx, v.Counters); err != nil {
+		v.Release()
+		return nil, err
+	}
+
+	return v, nil
+}
+
+// Release ends the read transaction.
+func (v *BikeView) Release() {
+	v.tx.Rollback()
+}
+
+// GetNodes selects the nodes metadata from the view.
+func (v *BikeView) GetNodes(nodes *[]*Node) error {
+	return getNodes(v.tx, nodes)
+}
+
+// GetQuotas selects the garages having a quota from the view.
+func (v *BikeView) GetQuotas(garages *[]*Garage) error {
+	return getQuotas(v.tx, garages)
+}
+
+// GetPartsAfter selects catalog parts whose ID is greater than after from the view, by ascending ID.
+func (v *BikeView) GetPartsAfter(after, limit uint64, parts *[]*Part) error {
+	return getParts(v.tx, parts, fmt.Sprintf("SELECT %s FROM catalog WHERE rowid > ? ORDER BY rowid LIMIT ?", partColumns), after, limit)
+}
+
+// GetBikesAfter selects the bikes of a garage whose ID is greater than after from the view, by ascending ID.
+// Bikes of every garage are selected when garage is empty.
+func (v *BikeView) GetBikesAfter(garage string, after, limit uint64, bikes *[]*Bike) error {
+	return getBikes(v.tx, bikes, "SELECT rowid, name, owner, garage FROM bike WHERE (? = '' OR garage = ?) AND rowid > ? ORDER BY rowid LIMIT ?", garage, garage, after, limit)
+}
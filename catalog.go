@@ -0,0 +1,182 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+)
+
+var (
+	// ErrUnknownCatalogPart is returned when a component references a missing catalog part.
+	ErrUnknownCatalogPart = errors.New("unknown catalog part")
+
+	// ErrCatalogPartInUse is returned when deleting a catalog part still referenced by a component.
+	ErrCatalogPartInUse = errors.New("catalog part in use")
+)
+
+// Part is a catalog entry shared by the components referencing it.
+type Part struct {
+	ID       uint64            `json:"id"`
+	Name     string            `json:"name"`
+	Category string            `json:"category"`
+	Brand    string            `json:"brand"`
+	Model    string            `json:"model"`
+	Weight   uint64            `json:"weight"`
+	Price    uint64            `json:"price"`
+	Specs    map[string]string `json:"specs,omitempty"`
+}
+
+const partColumns = "rowid, name, category, brand, model, weight, price, specs"
+
+type queryer interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// GetParts selects catalog parts from database.
+func (bs *BikeStore) GetParts(limit, offset uint64, parts *[]*Part) error {
+	return getParts(bs.DB, parts, fmt.Sprintf("SELECT %s FROM catalog ORDER BY rowid DESC LIMIT ? OFFSET ?", partColumns), limit, offset)
+}
+
+func getParts(q queryer, parts *[]*Part, query string, args ...interface{}) error {
+	rows, err := q.Query(query, args...)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		part := Part{}
+
+		if err := scanPart(rows, &part); err != nil {
+			return err
+		}
+
+		*parts = append(*parts, &part)
+	}
+
+	return rows.Err()
+}
+
+// GetPart gets a catalog part from database.
+func (bs *BikeStore) GetPart(id uint64, part *Part) error {
+	specs := ""
+	if err := bs.DB.QueryRow(fmt.Sprintf("SELECT %s FROM catalog WHERE rowid = ?", partColumns), id).Scan(&part.ID, &part.Name, &part.Category, &part.Brand, &part.Model, &part.Weight, &part.Price, &specs); err != nil {
+		return err
+	}
+
+	return decodeSpecs(specs, &part.Specs)
+}
+
+// StorePart inserts a catalog part into the database, the ID of the part is kept when set.
+func (t *BikeTx) StorePart(part *Part) error {
+	specs, err := encodeSpecs(part.Specs)
+	if err != nil {
+		return err
+	}
+
+	if part.ID, err = t.nextID(CatalogCounter, part.ID); err != nil {
+		return err
+	}
+
+	_, err = t.tx.Exec("INSERT INTO catalog(rowid, name, category, brand, model, weight, price, specs) VALUES(?, ?, ?, ?, ?, ?, ?, ?)",
+		part.ID, part.Name, part.Category, part.Brand, part.Model, part.Weight, part.Price, specs)
+	return err
+}
+
+// UpdatePart updates a catalog part, the change shows up in every component referencing it.
+func (t *BikeTx) UpdatePart(part *Part) error {
+	specs, err := encodeSpecs(part.Specs)
+	if err != nil {
+		return err
+	}
+
+	res, err := t.tx.Exec("UPDATE catalog SET name = ?, category = ?, brand = ?, model = ?, weight = ?, price = ?, specs = ? WHERE rowid = ?",
+		part.Name, part.Category, part.Brand, part.Model, part.Weight, part.Price, specs, part.ID)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// DeletePart deletes a catalog part which is not referenced by any component.
+func (t *BikeTx) DeletePart(id uint64) error {
+	used := false
+	if err := t.tx.QueryRow("SELECT EXISTS(SELECT 1 FROM component WHERE catalog_rowid = ?)", id).Scan(&used); err != nil {
+		return err
+	}
+
+	if used {
+		return ErrCatalogPartInUse
+	}
+
+	res, err := t.tx.Exec("DELETE FROM catalog WHERE rowid = ?", id)
+	if err != nil {
+		return err
+	}
+
+	return checkAffected(res)
+}
+
+// ResolveParts copies the attributes of the referenced catalog parts into some components.
+func (bs *BikeStore) ResolveParts(components []*Component) error {
+	for _, component := range components {
+		if component.CatalogID == 0 {
+			continue
+		}
+
+		part := Part{}
+		if err := bs.GetPart(component.CatalogID, &part); err != nil {
+			if err == sql.ErrNoRows {
+				return fmt.Errorf("%w %d", ErrUnknownCatalogPart, component.CatalogID)
+			}
+
+			return err
+		}
+
+		if component.Name == "" {
+			component.Name = part.Name
+		}
+
+		component.Category = part.Category
+		component.Brand = part.Brand
+		component.Model = part.Model
+		component.Weight = part.Weight
+		component.Price = part.Price
+		component.Specs = part.Specs
+	}
+
+	return nil
+}
+
+// Validate checks that the category of a part is known.
+func (p *Part) Validate() error {
+	return (&Component{Category: p.Category}).Validate()
+}
+
+func checkCatalogPart(q queryer, id uint64) error {
+	if id == 0 {
+		return nil
+	}
+
+	exists := false
+	if err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM catalog WHERE rowid = ?)", id).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return fmt.Errorf("%w %d", ErrUnknownCatalogPart, id)
+	}
+
+	return nil
+}
+
+func scanPart(rows *sql.Rows, part *Part) error {
+	specs := ""
+	if err := rows.Scan(&part.ID, &part.Name, &part.Category, &part.Brand, &part.Model, &part.Weight, &part.Price, &specs); err != nil {
+		return err
+	}
+
+	return decodeSpecs(specs, &part.Specs)
+}
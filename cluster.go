@@ -0,0 +1,159 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// JoinSeeds asks the seeds to add this node to the cluster until one of them succeeds,
+// nothing is done when the node is already part of the cluster configuration.
+func (app *Application) JoinSeeds(address raft.ServerAddress) {
+	future := app.Cluster.GetConfiguration()
+	if err := future.Error(); err != nil {
+		log.Printf("[JOIN] err=%s", err)
+		return
+	}
+
+	for _, server := range future.Configuration().Servers {
+		if server.ID == raft.ServerID(app.Config.LocalID) {
+			log.Printf("[JOIN] already member of the cluster")
+			return
+		}
+	}
+
+	body, err := json.Marshal(&JoinRequest{
+		ID:         app.Config.LocalID,
+		Address:    string(address),
+		APIAddress: app.Config.APIAddress,
+		Nonvoter:   app.Config.Nonvoter,
+	})
+	if err != nil {
+		log.Printf("[JOIN] err=%s", err)
+		return
+	}
+
+	retry := parseDuration(app.Config.JoinRetry)
+
+	for {
+		for _, seed := range app.Config.Seeds {
+			if err := app.sendRequest(http.MethodPost, app.apiURL(seed, "/cluster/join"), body); err != nil {
+				log.Printf("[JOIN] seed=%s err=%s", seed, err)
+				continue
+			}
+
+			log.Printf("[JOIN] joined through seed=%s", seed)
+			return
+		}
+
+		time.Sleep(retry)
+	}
+}
+
+// Leave prepares the shutdown of the node: the leader hands its leadership over to another
+// voter, and the node is removed from the cluster when leave_on_shutdown is set.
+func (app *Application) Leave() {
+	if app.Config.LeaveOnShutdown {
+		if app.Cluster.State() == raft.Leader {
+			// The leader steps down once its own removal is committed.
+			if err := app.Cluster.RemoveServer(raft.ServerID(app.Config.LocalID), 0, 0).Error(); err != nil {
+				log.Printf("[LEAVE] err=%s", err)
+			}
+
+			return
+		}
+
+		if err := app.requestLeader(http.MethodDelete, "/cluster/servers/"+app.Config.LocalID, nil); err != nil {
+			log.Printf("[LEAVE] err=%s", err)
+		}
+
+		return
+	}
+
+	if app.Cluster.State() != raft.Leader {
+		return
+	}
+
+	if err := app.Cluster.LeadershipTransfer().Error(); err != nil {
+		log.Printf("[LEAVE] err=%s", err)
+		return
+	}
+
+	log.Print("[LEAVE] leadership transferred")
+}
+
+// requestLeader sends a request to the API address published by the leader.
+func (app *Application) requestLeader(method, path string, body []byte) error {
+	leader, err := app.LeaderAPIAddress()
+	if err != nil {
+		return err
+	}
+
+	return app.sendRequest(method, app.apiURL(leader, path), body)
+}
+
+func (app *Application) sendRequest(method, url string, body []byte) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	req, err := http.NewRequestWithContext(ctx, method, url, bytes.NewBuffer(body))
+	if err != nil {
+		return err
+	}
+
+	req.Header.Set("Content-Type", "application/json")
+
+	if app.Config.Auth != nil {
+		req.Header.Set(APIKeyHeader, app.Config.Auth.NodeAPIKey)
+	}
+
+	resp, err := app.Client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode/100 != 2 {
+		respBody, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("%s: %s", resp.Status, respBody)
+	}
+
+	return nil
+}
+
+// apiURL builds the URL of an API path on a node, HTTPS is used when the address has no scheme and api_tls is set.
+func (app *Application) apiURL(address, path string) string {
+	if !strings.Contains(address, "://") {
+		if app.Config.APITLS != nil {
+			address = "https://" + address
+		} else {
+			address = "http://" + address
+		}
+	}
+
+	return strings.TrimRight(address, "/") + path
+}
+
+// NewClient creates the HTTP client used to call the other nodes, it trusts the same CA and presents
+// the same certificate as the API server when api_tls is set.
+func NewClient(tlsConfig *tls.Config) *http.Client {
+	if tlsConfig == nil {
+		return &http.Client{}
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = tlsConfig
+
+	return &http.Client{
+		Transport: transport,
+	}
+}
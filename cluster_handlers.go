@@ -0,0 +1,493 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"html/template"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/gorilla/mux"
+	"github.com/hashicorp/raft"
+)
+
+// ErrUnknownServer is returned when a server is not part of the cluster configuration.
+var ErrUnknownServer = errors.New("unknown server")
+
+// ServerInfo describes a member of the cluster.
+type ServerInfo struct {
+	ID         string `json:"id"`
+	Address    string `json:"address"`
+	APIAddress string `json:"api_address,omitempty"`
+	Suffrage   string `json:"suffrage"`
+}
+
+// ClusterStatus describes the state of the cluster seen from a node.
+type ClusterStatus struct {
+	ID                string        `json:"id"`
+	State             string        `json:"state"`
+	LeaderID          string        `json:"leader_id"`
+	LeaderAddress     string        `json:"leader_address"`
+	Term              uint64        `json:"term"`
+	CommitIndex       uint64        `json:"commit_index"`
+	AppliedIndex      uint64        `json:"applied_index"`
+	LastSnapshotIndex uint64        `json:"last_snapshot_index"`
+	LastContact       string        `json:"last_contact"`
+	Servers           []*ServerInfo `json:"servers"`
+}
+
+// JoinRequest is the body of POST /cluster/join.
+type JoinRequest struct {
+	ID         string `json:"id"`
+	Address    string `json:"address"`
+	APIAddress string `json:"api_address"`
+	Nonvoter   bool   `json:"nonvoter"`
+}
+
+// TransferRequest is the body of POST /cluster/leadership/transfer.
+type TransferRequest struct {
+	ID string `json:"id"`
+}
+
+// GetClusterHandler is a REST handler.
+type GetClusterHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /cluster.
+func (h *GetClusterHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := ClusterStatus{}
+	if err := h.Application.GetClusterStatus(&status); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &status)
+}
+
+// DashboardHandler renders the cluster dashboard.
+type DashboardHandler struct {
+	Application *Application
+	Template    *template.Template
+}
+
+// ServeHTTP handles GET /dashboard.
+func (h *DashboardHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	status := ClusterStatus{}
+	if err := h.Application.GetClusterStatus(&status); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+	h.Template.ExecuteTemplate(w, "cluster.tmpl", &status)
+}
+
+// JoinHandler is a REST handler.
+type JoinHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /cluster/join.
+func (h *JoinHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	join := JoinRequest{}
+	if err := json.Unmarshal(body, &join); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if join.ID == "" || join.Address == "" {
+		writeError(w, http.StatusBadRequest, errors.New("id and address are required"))
+		return
+	}
+
+	if err := h.Application.Join(&join); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.Application.writeServers(w)
+}
+
+// PutNodeHandler is a REST handler.
+type PutNodeHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles PUT /cluster/nodes/:id.
+func (h *PutNodeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	node := Node{}
+	if err := json.Unmarshal(body, &node); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	node.ID = mux.Vars(r)["id"]
+
+	if node.APIAddress == "" {
+		writeError(w, http.StatusBadRequest, errors.New("api_address is required"))
+		return
+	}
+
+	if _, err := h.Application.GetServer(raft.ServerID(node.ID)); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	resp, err := h.Application.Apply(r.Context(), SetNodeOp, &node)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(resp.Index, 10))
+
+	if resp.Err != nil {
+		writeError(w, statusOf(resp.Err), resp.Err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &node)
+}
+
+// TransferLeadershipHandler is a REST handler.
+type TransferLeadershipHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /cluster/leadership/transfer, the leadership goes to the server given by id or to the most up to date voter.
+func (h *TransferLeadershipHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	transfer := TransferRequest{}
+	if len(body) > 0 {
+		if err := json.Unmarshal(body, &transfer); err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return
+		}
+	}
+
+	var future raft.Future
+	if transfer.ID != "" {
+		server, err := h.Application.GetServer(raft.ServerID(transfer.ID))
+		if err != nil {
+			writeError(w, statusOf(err), err)
+			return
+		}
+
+		future = h.Application.Cluster.LeadershipTransferToServer(server.ID, server.Address)
+	} else {
+		future = h.Application.Cluster.LeadershipTransfer()
+	}
+
+	if err := future.Error(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := ClusterStatus{}
+	if err := h.Application.GetClusterStatus(&status); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &status)
+}
+
+// SnapshotHandler is a REST handler.
+type SnapshotHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /cluster/snapshot, the snapshot is taken by the node receiving the request.
+func (h *SnapshotHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := h.Application.Cluster.Snapshot().Error(); err != nil {
+		if err == raft.ErrNothingNewToSnapshot {
+			writeError(w, http.StatusConflict, err)
+			return
+		}
+
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	status := ClusterStatus{}
+	if err := h.Application.GetClusterStatus(&status); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &status)
+}
+
+// RemoveServerHandler is a REST handler.
+type RemoveServerHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles DELETE /cluster/servers/:id.
+func (h *RemoveServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	server, err := h.Application.GetServer(raft.ServerID(mux.Vars(r)["id"]))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := h.Application.Cluster.RemoveServer(server.ID, 0, 0).Error(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.Application.writeServers(w)
+}
+
+// PromoteServerHandler is a REST handler.
+type PromoteServerHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /cluster/servers/:id/promote.
+func (h *PromoteServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	server, err := h.Application.GetServer(raft.ServerID(mux.Vars(r)["id"]))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := h.Application.Cluster.AddVoter(server.ID, server.Address, 0, 0).Error(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.Application.writeServers(w)
+}
+
+// DemoteServerHandler is a REST handler.
+type DemoteServerHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /cluster/servers/:id/demote.
+func (h *DemoteServerHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	server, err := h.Application.GetServer(raft.ServerID(mux.Vars(r)["id"]))
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := h.Application.Cluster.DemoteVoter(server.ID, 0, 0).Error(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	h.Application.writeServers(w)
+}
+
+// GetClusterStatus reads the Raft stats and configuration.
+func (app *Application) GetClusterStatus(status *ClusterStatus) error {
+	future := app.Cluster.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return err
+	}
+
+	stats := app.Cluster.Stats()
+	leader := app.Cluster.Leader()
+
+	status.ID = app.Config.LocalID
+	status.State = stats["state"]
+	status.LeaderAddress = string(leader)
+	status.Term = parseStat(stats, "term")
+	status.CommitIndex = parseStat(stats, "commit_index")
+	status.AppliedIndex = parseStat(stats, "applied_index")
+	status.LastSnapshotIndex = parseStat(stats, "last_snapshot_index")
+	status.LastContact = stats["last_contact"]
+	status.Servers = app.newServerInfos(future.Configuration().Servers)
+
+	for _, server := range future.Configuration().Servers {
+		if server.Address == leader {
+			status.LeaderID = string(server.ID)
+		}
+	}
+
+	return nil
+}
+
+// Join adds a server to the cluster and publishes its API address when given, nothing is done when it is already a member with the same addresses and suffrage.
+func (app *Application) Join(join *JoinRequest) error {
+	if err := app.addServer(join); err != nil {
+		return err
+	}
+
+	if join.APIAddress == "" {
+		return nil
+	}
+
+	node := Node{}
+	if err := app.BikeStore.GetNode(join.ID, &node); err == nil && node.APIAddress == join.APIAddress {
+		return nil
+	}
+
+	resp, err := app.Apply(context.Background(), SetNodeOp, &Node{
+		ID:         join.ID,
+		APIAddress: join.APIAddress,
+	})
+	if err != nil {
+		return err
+	}
+
+	return resp.Err
+}
+
+func (app *Application) addServer(join *JoinRequest) error {
+	server, err := app.GetServer(raft.ServerID(join.ID))
+	if err != nil && err != ErrUnknownServer {
+		return err
+	}
+
+	suffrage := raft.Voter
+	if join.Nonvoter {
+		suffrage = raft.Nonvoter
+	}
+
+	if server != nil && server.Address == raft.ServerAddress(join.Address) && server.Suffrage == suffrage {
+		return nil
+	}
+
+	if join.Nonvoter {
+		return app.Cluster.AddNonvoter(raft.ServerID(join.ID), raft.ServerAddress(join.Address), 0, 0).Error()
+	}
+
+	return app.Cluster.AddVoter(raft.ServerID(join.ID), raft.ServerAddress(join.Address), 0, 0).Error()
+}
+
+// GetServer finds a server in the cluster configuration.
+func (app *Application) GetServer(id raft.ServerID) (*raft.Server, error) {
+	future := app.Cluster.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return nil, err
+	}
+
+	for _, server := range future.Configuration().Servers {
+		if server.ID == id {
+			return &server, nil
+		}
+	}
+
+	return nil, ErrUnknownServer
+}
+
+func (app *Application) writeServers(w http.ResponseWriter) {
+	future := app.Cluster.GetConfiguration()
+	if err := future.Error(); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, app.newServerInfos(future.Configuration().Servers))
+}
+
+func parseStat(stats map[string]string, key string) uint64 {
+	v, _ := strconv.ParseUint(stats[key], 10, 64)
+	return v
+}
+
+func (app *Application) newServerInfos(servers []raft.Server) []*ServerInfo {
+	infos := []*ServerInfo{}
+	for _, server := range servers {
+		node := Node{}
+		app.BikeStore.GetNode(string(server.ID), &node)
+
+		infos = append(infos, &ServerInfo{
+			ID:         string(server.ID),
+			Address:    string(server.Address),
+			APIAddress: node.APIAddress,
+			Suffrage:   strings.ToLower(server.Suffrage.String()),
+		})
+	}
+
+	return infos
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+)
+
+// CommandVersion is the schema version of the commands written to the Raft log.
+const CommandVersion = 1
+
+// Op is the operation carried by a command.
+type Op string
+
+const (
+	// CreateBikeOp creates a bike.
+	CreateBikeOp Op = "create_bike"
+
+	// UpdateBikeOp replaces a bike.
+	UpdateBikeOp Op = "update_bike"
+
+	// PatchBikeOp renames a bike and/or replaces its components.
+	PatchBikeOp Op = "patch_bike"
+
+	// DeleteBikeOp deletes a bike.
+	DeleteBikeOp Op = "delete_bike"
+
+	// CreateComponentOp adds a component to a bike.
+	CreateComponentOp Op = "create_component"
+
+	// UpdateComponentOp replaces a component of a bike.
+	UpdateComponentOp Op = "update_component"
+
+	// DeleteComponentOp removes a component from a bike.
+	DeleteComponentOp Op = "delete_component"
+
+	// CreatePartOp adds a part to the catalog.
+	CreatePartOp Op = "create_part"
+
+	// UpdatePartOp replaces a catalog part.
+	UpdatePartOp Op = "update_part"
+
+	// DeletePartOp removes a part from the catalog.
+	DeletePartOp Op = "delete_part"
+
+	// SetNodeOp publishes the metadata of a node.
+	SetNodeOp Op = "set_node"
+
+	// SetGarageOp sets the quota of a garage.
+	SetGarageOp Op = "set_garage"
+
+	// DeleteGarageOp deletes a garage and its bikes.
+	DeleteGarageOp Op = "delete_garage"
+)
+
+// Command is the envelope of every command written to the Raft log.
+type Command struct {
+	Op        Op              `json:"op"`
+	Version   int             `json:"version"`
+	Principal string          `json:"principal,omitempty"`
+	Garage    string          `json:"garage,omitempty"`
+	Payload   json.RawMessage `json:"payload"`
+}
+
+// BikePatch is the payload of a PatchBikeOp command, nil fields are left untouched.
+type BikePatch struct {
+	ID         uint64        `json:"id"`
+	Name       *string       `json:"name,omitempty"`
+	Components *[]*Component `json:"components,omitempty"`
+}
+
+// BikeKey is the payload of a DeleteBikeOp command.
+type BikeKey struct {
+	ID uint64 `json:"id"`
+}
+
+// ComponentKey is the payload of a DeleteComponentOp command.
+type ComponentKey struct {
+	BikeID uint64 `json:"bike_id"`
+	ID     uint64 `json:"id"`
+}
+
+// PartKey is the payload of a DeletePartOp command.
+type PartKey struct {
+	ID uint64 `json:"id"`
+}
+
+// NewCommand creates a command.
+func NewCommand(op Op, payload interface{}) (*Command, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Command{
+		Op:      op,
+		Version: CommandVersion,
+		Payload: data,
+	}, nil
+}
+
+// DecodeCommand decodes a command from a log, logs written as plain bike JSON are decoded as a bike creation
+// and commands without garage are applied to the default one.
+func DecodeCommand(data []byte, cmd *Command) error {
+	if err := json.Unmarshal(data, cmd); err != nil {
+		return err
+	}
+
+	if cmd.Garage == "" {
+		cmd.Garage = DefaultGarage
+	}
+
+	if cmd.Op == "" {
+		cmd.Op = CreateBikeOp
+		cmd.Version = 0
+		cmd.Payload = data
+
+		return nil
+	}
+
+	if cmd.Version > CommandVersion {
+		return fmt.Errorf("unsupported command version %d", cmd.Version)
+	}
+
+	return nil
+}
+
+// Apply replicates a command made on behalf of the principal of ctx, within its garage, through the Raft cluster, the response carries the index of its log.
+func (app *Application) Apply(ctx context.Context, op Op, payload interface{}) (*ApplyResponse, error) {
+	cmd, err := NewCommand(op, payload)
+	if err != nil {
+		return nil, err
+	}
+
+	if principal := PrincipalFrom(ctx); principal != nil {
+		cmd.Principal = principal.Name
+	}
+
+	cmd.Garage = GarageFrom(ctx)
+
+	data, err := json.Marshal(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	apply := app.Cluster.Apply(data, 0)
+	if err := apply.Error(); err != nil {
+		return nil, err
+	}
+
+	resp := apply.Response().(*ApplyResponse)
+	resp.Index = apply.Index()
+
+	return resp, nil
+}
@@ -0,0 +1,200 @@
+package main
+
+import (
+	"fmt"
+)
+
+// Severity is the level of a compatibility issue.
+type Severity string
+
+const (
+	// Warning is raised when parts fit only with an adapter.
+	Warning Severity = "warning"
+
+	// Error is raised when parts do not fit together.
+	Error Severity = "error"
+)
+
+// Side selects a spec of the components of a category.
+type Side struct {
+	Category string
+	Spec     string
+}
+
+// Rule is a declarative compatibility rule between the specs of two sides.
+// Compatible lists the right values fitting a left value, both values must be equal when it is nil.
+// Adapters lists the right values fitting a left value with an adapter, they raise a warning.
+type Rule struct {
+	Name       string
+	Severity   Severity
+	Left       Side
+	Right      Side
+	Compatible map[string][]string
+	Adapters   map[string][]string
+}
+
+// Issue is a compatibility rule violation.
+type Issue struct {
+	Rule       string   `json:"rule"`
+	Severity   Severity `json:"severity"`
+	Message    string   `json:"message"`
+	Components []uint64 `json:"components"`
+}
+
+// Rules lists the compatibility rules checked against the components of a bike.
+var Rules = []*Rule{
+	{
+		Name:     "head_tube",
+		Severity: Error,
+		Left:     Side{Category: "frame", Spec: "head_tube"},
+		Right:    Side{Category: "fork", Spec: "steerer"},
+		Compatible: map[string][]string{
+			"1-1/8":   {"1-1/8"},
+			"tapered": {"tapered"},
+			"1.5":     {"1.5"},
+		},
+		Adapters: map[string][]string{
+			"tapered": {"1-1/8"},
+			"1.5":     {"1-1/8", "tapered"},
+		},
+	},
+	{
+		Name:     "bottom_bracket",
+		Severity: Error,
+		Left:     Side{Category: "frame", Spec: "bottom_bracket"},
+		Right:    Side{Category: "drivetrain", Spec: "bottom_bracket"},
+	},
+	{
+		Name:     "speeds",
+		Severity: Error,
+		Left:     Side{Category: "drivetrain", Spec: "speeds"},
+		Right:    Side{Category: "drivetrain", Spec: "speeds"},
+	},
+	{
+		Name:     "shifter_speeds",
+		Severity: Error,
+		Left:     Side{Category: "cockpit", Spec: "speeds"},
+		Right:    Side{Category: "drivetrain", Spec: "speeds"},
+	},
+	{
+		Name:     "brake_type",
+		Severity: Error,
+		Left:     Side{Category: "brakes", Spec: "brake"},
+		Right:    Side{Category: "wheelset", Spec: "brake"},
+	},
+	{
+		Name:     "frame_brake_type",
+		Severity: Error,
+		Left:     Side{Category: "frame", Spec: "brake"},
+		Right:    Side{Category: "brakes", Spec: "brake"},
+	},
+	{
+		Name:     "fork_brake_type",
+		Severity: Error,
+		Left:     Side{Category: "fork", Spec: "brake"},
+		Right:    Side{Category: "brakes", Spec: "brake"},
+	},
+	{
+		Name:     "frame_wheel_size",
+		Severity: Error,
+		Left:     Side{Category: "frame", Spec: "wheel_size"},
+		Right:    Side{Category: "wheelset", Spec: "wheel_size"},
+	},
+	{
+		Name:     "fork_wheel_size",
+		Severity: Error,
+		Left:     Side{Category: "fork", Spec: "wheel_size"},
+		Right:    Side{Category: "wheelset", Spec: "wheel_size"},
+	},
+	{
+		Name:     "tire_wheel_size",
+		Severity: Error,
+		Left:     Side{Category: "wheelset", Spec: "wheel_size"},
+		Right:    Side{Category: "tires", Spec: "wheel_size"},
+	},
+	{
+		Name:     "rear_axle",
+		Severity: Error,
+		Left:     Side{Category: "frame", Spec: "rear_axle"},
+		Right:    Side{Category: "wheelset", Spec: "rear_axle"},
+	},
+	{
+		Name:     "front_axle",
+		Severity: Error,
+		Left:     Side{Category: "fork", Spec: "front_axle"},
+		Right:    Side{Category: "wheelset", Spec: "front_axle"},
+	},
+}
+
+// CheckCompatibility checks some components against the compatibility rules.
+func CheckCompatibility(components []*Component) []*Issue {
+	issues := []*Issue{}
+
+	for _, rule := range Rules {
+		for i, left := range components {
+			lv, ok := left.Specs[rule.Left.Spec]
+			if !ok || left.Category != rule.Left.Category {
+				continue
+			}
+
+			for j, right := range components {
+				if i == j || (rule.Left == rule.Right && j < i) {
+					continue
+				}
+
+				rv, ok := right.Specs[rule.Right.Spec]
+				if !ok || right.Category != rule.Right.Category {
+					continue
+				}
+
+				if issue := rule.check(left, lv, right, rv); issue != nil {
+					issues = append(issues, issue)
+				}
+			}
+		}
+	}
+
+	return issues
+}
+
+// HasErrors tells if some issues prevent a bike from being built.
+func HasErrors(issues []*Issue) bool {
+	for _, issue := range issues {
+		if issue.Severity == Error {
+			return true
+		}
+	}
+
+	return false
+}
+
+func (rule *Rule) check(left *Component, lv string, right *Component, rv string) *Issue {
+	if (rule.Compatible == nil && lv == rv) || contains(rule.Compatible[lv], rv) {
+		return nil
+	}
+
+	issue := &Issue{
+		Rule:       rule.Name,
+		Severity:   rule.Severity,
+		Components: []uint64{left.ID, right.ID},
+	}
+
+	if contains(rule.Adapters[lv], rv) {
+		issue.Severity = Warning
+		issue.Message = fmt.Sprintf("%s %s %q fits %s %s %q only with an adapter", left.Name, rule.Left.Spec, lv, right.Name, rule.Right.Spec, rv)
+	} else {
+		issue.Message = fmt.Sprintf("%s %s %q does not fit %s %s %q", left.Name, rule.Left.Spec, lv, right.Name, rule.Right.Spec, rv)
+	}
+
+	return issue
+}
+
+func contains(values []string, value string) bool {
+	for _, v := range values {
+		if v == value {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,155 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// Consistency is the consistency mode of a read.
+type Consistency string
+
+const (
+	// StaleConsistency reads the local database, followers may return stale data.
+	StaleConsistency Consistency = "stale"
+
+	// LeaderConsistency forwards the read to the leader which reads its local database.
+	LeaderConsistency Consistency = "leader"
+
+	// LinearizableConsistency forwards the read to the leader which confirms its leadership
+	// and waits for every committed log to be applied before reading.
+	LinearizableConsistency Consistency = "linearizable"
+)
+
+// ConsistencyHeader is the header used to select a consistency mode when the consistency query parameter is missing.
+const ConsistencyHeader = "X-Consistency"
+
+// RaftIndexHeader is the response header giving the Raft index of a write, a read sent with
+// this header, or an index query parameter, waits until the node has applied that index.
+const RaftIndexHeader = "X-Raft-Index"
+
+// ErrReadTimeout is returned when a node has not applied the index required by a read in time.
+var ErrReadTimeout = errors.New("read index not applied in time")
+
+// AppliedIndexHeader is the response header giving the Raft index held by the bike store of the node which served a read.
+const AppliedIndexHeader = "X-Applied-Index"
+
+// Consistent prepares a read according to its consistency mode and waits for the Raft index
+// it requires, false is returned when the response has already been written, either because
+// of an error or because the read was forwarded.
+func (app *Application) Consistent(w http.ResponseWriter, r *http.Request) bool {
+	consistency := Consistency(r.URL.Query().Get("consistency"))
+	if consistency == "" {
+		consistency = Consistency(r.Header.Get(ConsistencyHeader))
+	}
+
+	switch consistency {
+	case "", StaleConsistency:
+	case LeaderConsistency, LinearizableConsistency:
+		if app.Cluster.State() != raft.Leader {
+			app.Forward(w, r, nil)
+			return false
+		}
+
+		if consistency == LinearizableConsistency {
+			if err := app.Cluster.VerifyLeader().Error(); err != nil {
+				writeError(w, statusOf(err), err)
+				return false
+			}
+
+			if err := app.Cluster.Barrier(0).Error(); err != nil {
+				writeError(w, statusOf(err), err)
+				return false
+			}
+		}
+	default:
+		writeError(w, http.StatusBadRequest, fmt.Errorf("unknown consistency %q", consistency))
+		return false
+	}
+
+	index := r.URL.Query().Get("index")
+	if index == "" {
+		index = r.Header.Get(RaftIndexHeader)
+	}
+
+	if index != "" {
+		i, err := strconv.ParseUint(index, 10, 64)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, err)
+			return false
+		}
+
+		if err := app.WaitApplied(i, parseDuration(app.Config.ReadTimeout)); err != nil {
+			writeError(w, statusOf(err), err)
+			return false
+		}
+	}
+
+	w.Header().Set(AppliedIndexHeader, strconv.FormatUint(app.AppliedIndex.Load(), 10))
+
+	return true
+}
+
+// WaitApplied waits until the bike store of the node holds the given Raft index or the timeout expires.
+func (app *Application) WaitApplied(index uint64, timeout time.Duration) error {
+	return app.AppliedIndex.Wait(index, timeout)
+}
+
+// AppliedIndex is the last Raft index applied to the bike store by the FSM. Raft reports logs as applied
+// as soon as they are handed to the FSM, readers waiting for their writes must wait for this index instead.
+type AppliedIndex struct {
+	index   uint64
+	mu      sync.Mutex
+	changed chan struct{}
+}
+
+// NewAppliedIndex creates an applied index.
+func NewAppliedIndex(index uint64) *AppliedIndex {
+	return &AppliedIndex{
+		index:   index,
+		changed: make(chan struct{}),
+	}
+}
+
+// Load returns the applied index.
+func (a *AppliedIndex) Load() uint64 {
+	return atomic.LoadUint64(&a.index)
+}
+
+// Store sets the applied index and wakes up the waiters.
+func (a *AppliedIndex) Store(index uint64) {
+	atomic.StoreUint64(&a.index, index)
+
+	a.mu.Lock()
+	close(a.changed)
+	a.changed = make(chan struct{})
+	a.mu.Unlock()
+}
+
+// Wait waits until the applied index reaches index, ErrReadTimeout is returned when the timeout expires first.
+func (a *AppliedIndex) Wait(index uint64, timeout time.Duration) error {
+	timer := time.NewTimer(timeout)
+	defer timer.Stop()
+
+	for {
+		a.mu.Lock()
+		changed := a.changed
+		a.mu.Unlock()
+
+		if a.Load() >= index {
+			return nil
+		}
+
+		select {
+		case <-changed:
+		case <-timer.C:
+			return ErrReadTimeout
+		}
+	}
+}
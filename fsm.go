@@ -2,63 +2,446 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
+	"fmt"
 	"io"
 	"log"
 
 	"github.com/hashicorp/raft"
 )
 
+// ErrAlreadyApplied is returned for a command whose log index is not above the index of the bike store,
+// which happens when the Raft log is replayed or was reset while the bike store was kept.
+var ErrAlreadyApplied = errors.New("log index already applied to the bike store")
+
 // FSM is the Raft FSM.
 type FSM struct {
-	BikeStore *BikeStore
+	BikeStore    *BikeStore
+	Compression  SnapshotCompression
+	AppliedIndex *AppliedIndex
 }
 
 // ApplyResponse is to get Apply future response.
 type ApplyResponse struct {
-	Bike *Bike
-	Err  error
+	Bike      *Bike
+	Component *Component
+	Part      *Part
+	Index     uint64
+	Err       error
 }
 
-// NewFSM creates a FSM.
-func NewFSM(bikeStore *BikeStore) (*FSM, error) {
+// NewFSM creates a FSM, its snapshots are compressed with the given compression.
+func NewFSM(bikeStore *BikeStore, compression SnapshotCompression) (*FSM, error) {
+	appliedIndex, err := bikeStore.AppliedIndex()
+	if err != nil {
+		return nil, err
+	}
+
 	return &FSM{
-		BikeStore: bikeStore,
+		BikeStore:    bikeStore,
+		Compression:  compression,
+		AppliedIndex: NewAppliedIndex(appliedIndex),
 	}, nil
 }
 
-// Apply stores the bike contained in the log.
+// Apply applies the command contained in the log, logs already applied to the bike store are skipped
+// with ErrAlreadyApplied.
+// The applied index is published once the command has been applied.
 func (fsm *FSM) Apply(l *raft.Log) interface{} {
 	log.Printf("[APPLY] log=%#v", l)
 
 	switch l.Type {
 	case raft.LogCommand:
-		bike := Bike{}
-		if err := json.Unmarshal(l.Data, &bike); err != nil {
+		appliedIndex := fsm.AppliedIndex.Load()
+		if l.Index <= appliedIndex {
+			log.Printf("[ERROR] [APPLY] skipped index=%d applied_index=%d", l.Index, appliedIndex)
+
 			return &ApplyResponse{
-				Err: err,
+				Err: fmt.Errorf("%w: index %d, applied index %d", ErrAlreadyApplied, l.Index, appliedIndex),
 			}
 		}
 
-		if err := fsm.BikeStore.StoreBike(&bike); err != nil {
-			return &ApplyResponse{
-				Err: err,
-			}
+		resp := fsm.applyCommand(l)
+		fsm.AppliedIndex.Store(l.Index)
+
+		return resp
+	}
+
+	return nil
+}
+
+func (fsm *FSM) applyCommand(l *raft.Log) *ApplyResponse {
+	cmd := Command{}
+	if err := DecodeCommand(l.Data, &cmd); err != nil {
+		return &ApplyResponse{
+			Err: err,
 		}
+	}
+
+	log.Printf("[APPLY] op=%s principal=%q garage=%q", cmd.Op, cmd.Principal, cmd.Garage)
+
+	switch cmd.Op {
+	case CreateBikeOp:
+		return fsm.applyCreateBike(l.Index, &cmd)
+	case UpdateBikeOp:
+		return fsm.applyUpdateBike(l.Index, &cmd)
+	case PatchBikeOp:
+		return fsm.applyPatchBike(l.Index, &cmd)
+	case DeleteBikeOp:
+		return fsm.applyDeleteBike(l.Index, &cmd)
+	case CreateComponentOp:
+		return fsm.applyCreateComponent(l.Index, &cmd)
+	case UpdateComponentOp:
+		return fsm.applyUpdateComponent(l.Index, &cmd)
+	case DeleteComponentOp:
+		return fsm.applyDeleteComponent(l.Index, &cmd)
+	case CreatePartOp:
+		return fsm.applyCreatePart(l.Index, &cmd)
+	case UpdatePartOp:
+		return fsm.applyUpdatePart(l.Index, &cmd)
+	case DeletePartOp:
+		return fsm.applyDeletePart(l.Index, &cmd)
+	case SetNodeOp:
+		return fsm.applySetNode(l.Index, &cmd)
+	case SetGarageOp:
+		return fsm.applySetGarage(l.Index, &cmd)
+	case DeleteGarageOp:
+		return fsm.applyDeleteGarage(l.Index, &cmd)
+	}
+
+	return &ApplyResponse{
+		Err: fmt.Errorf("unknown command operation %q", cmd.Op),
+	}
+}
 
+func (fsm *FSM) applyCreateBike(index uint64, cmd *Command) *ApplyResponse {
+	bike := Bike{}
+	if err := json.Unmarshal(cmd.Payload, &bike); err != nil {
 		return &ApplyResponse{
-			Bike: &bike,
+			Err: err,
 		}
 	}
 
-	return nil
+	bike.ID = 0
+	bike.Owner = cmd.Principal
+	bike.Garage = cmd.Garage
+	bike.ClearComponentIDs()
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if err := t.CheckQuota(cmd.Garage); err != nil {
+			return err
+		}
+
+		return t.StoreBikes([]*Bike{&bike})
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Bike: &bike,
+	}
+}
+
+func (fsm *FSM) applyUpdateBike(index uint64, cmd *Command) *ApplyResponse {
+	bike := Bike{}
+	if err := json.Unmarshal(cmd.Payload, &bike); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	bike.ClearComponentIDs()
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if err := t.CheckGarage(cmd.Garage, bike.ID); err != nil {
+			return err
+		}
+
+		return t.ReplaceBike(&bike)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Bike: &bike,
+	}
+}
+
+func (fsm *FSM) applyPatchBike(index uint64, cmd *Command) *ApplyResponse {
+	patch := BikePatch{}
+	if err := json.Unmarshal(cmd.Payload, &patch); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.CheckGarage(cmd.Garage, patch.ID); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	bike := Bike{}
+	if err := fsm.BikeStore.GetBike(patch.ID, &bike); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if patch.Name != nil {
+		bike.Name = *patch.Name
+	}
+
+	if patch.Components != nil {
+		bike.Components = *patch.Components
+		bike.ClearComponentIDs()
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if patch.Components != nil {
+			return t.ReplaceBike(&bike)
+		}
+
+		return t.RenameBike(bike.ID, bike.Name)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Bike: &bike,
+	}
 }
 
-// Snapshot creates a snapshot.
+func (fsm *FSM) applyDeleteBike(index uint64, cmd *Command) *ApplyResponse {
+	key := BikeKey{}
+	if err := json.Unmarshal(cmd.Payload, &key); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if err := t.CheckGarage(cmd.Garage, key.ID); err != nil {
+			return err
+		}
+
+		return t.DeleteBike(key.ID)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{}
+}
+
+func (fsm *FSM) applyCreateComponent(index uint64, cmd *Command) *ApplyResponse {
+	component := Component{}
+	if err := json.Unmarshal(cmd.Payload, &component); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if err := t.CheckGarage(cmd.Garage, component.BikeID); err != nil {
+			return err
+		}
+
+		return t.StoreComponent(&component)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Component: &component,
+	}
+}
+
+func (fsm *FSM) applyUpdateComponent(index uint64, cmd *Command) *ApplyResponse {
+	component := Component{}
+	if err := json.Unmarshal(cmd.Payload, &component); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if err := t.CheckGarage(cmd.Garage, component.BikeID); err != nil {
+			return err
+		}
+
+		return t.UpdateComponent(&component)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Component: &component,
+	}
+}
+
+func (fsm *FSM) applyDeleteComponent(index uint64, cmd *Command) *ApplyResponse {
+	key := ComponentKey{}
+	if err := json.Unmarshal(cmd.Payload, &key); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		if err := t.CheckGarage(cmd.Garage, key.BikeID); err != nil {
+			return err
+		}
+
+		return t.DeleteComponent(key.BikeID, key.ID)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{}
+}
+
+func (fsm *FSM) applyCreatePart(index uint64, cmd *Command) *ApplyResponse {
+	part := Part{}
+	if err := json.Unmarshal(cmd.Payload, &part); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		return t.StorePart(&part)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Part: &part,
+	}
+}
+
+func (fsm *FSM) applyUpdatePart(index uint64, cmd *Command) *ApplyResponse {
+	part := Part{}
+	if err := json.Unmarshal(cmd.Payload, &part); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		return t.UpdatePart(&part)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{
+		Part: &part,
+	}
+}
+
+func (fsm *FSM) applyDeletePart(index uint64, cmd *Command) *ApplyResponse {
+	key := PartKey{}
+	if err := json.Unmarshal(cmd.Payload, &key); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		return t.DeletePart(key.ID)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{}
+}
+
+func (fsm *FSM) applySetNode(index uint64, cmd *Command) *ApplyResponse {
+	node := Node{}
+	if err := json.Unmarshal(cmd.Payload, &node); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		return t.StoreNode(&node)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{}
+}
+
+func (fsm *FSM) applySetGarage(index uint64, cmd *Command) *ApplyResponse {
+	garage := Garage{}
+	if err := json.Unmarshal(cmd.Payload, &garage); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	garage.Name = cmd.Garage
+
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		return t.SetGarage(&garage)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{}
+}
+
+func (fsm *FSM) applyDeleteGarage(index uint64, cmd *Command) *ApplyResponse {
+	if err := fsm.BikeStore.Update(index, func(t *BikeTx) error {
+		return t.DeleteGarage(cmd.Garage)
+	}); err != nil {
+		return &ApplyResponse{
+			Err: err,
+		}
+	}
+
+	return &ApplyResponse{}
+}
+
+// Snapshot creates a snapshot from a view of the bike store opened before any other log is applied.
 func (fsm *FSM) Snapshot() (raft.FSMSnapshot, error) {
-	return NewSnapshot(fsm.BikeStore)
+	view, err := fsm.BikeStore.View()
+	if err != nil {
+		return nil, err
+	}
+
+	view.Counters[AppliedIndexCounter] = fsm.AppliedIndex.Load()
+
+	return NewSnapshot(view, view.Counters, fsm.Compression)
 }
 
-// Restore store some bikes from a snapshot.
+// Restore replaces the content of the bike store by a snapshot, keeping the IDs of its records. Nothing is
+// replaced when the snapshot is corrupted or uses an unsupported format. The snapshot is streamed, bikes
+// are stored in batches of Limit.
 func (fsm *FSM) Restore(rClose io.ReadCloser) error {
 	defer func() {
 		if err := rClose.Close(); err != nil {
@@ -70,21 +453,84 @@ func (fsm *FSM) Restore(rClose io.ReadCloser) error {
 
 	restored := 0
 
-	decoder := json.NewDecoder(rClose)
-	for decoder.More() {
-		bike := Bike{}
-		if err := decoder.Decode(&bike); err != nil {
-			return err
+	counters := map[string]uint64{}
+
+	reader, err := NewSnapshotReader(rClose)
+	if err != nil {
+		return err
+	}
+	defer reader.Close()
+
+	err = fsm.BikeStore.Replace(func(t *BikeTx) error {
+		bikes := make([]*Bike, 0, Limit)
+
+		for {
+			record := SnapshotRecord{}
+			if err := reader.Read(&record); err != nil {
+				if err == io.EOF {
+					break
+				}
+
+				return err
+			}
+
+			switch {
+			case record.Counters != nil:
+				for name, value := range record.Counters {
+					if err := t.SetCounter(name, value); err != nil {
+						return err
+					}
+
+					counters[name] = value
+				}
+			case record.Quota != nil:
+				if err := t.SetGarage(record.Quota); err != nil {
+					return err
+				}
+			case record.Node != nil:
+				if err := t.StoreNode(record.Node); err != nil {
+					return err
+				}
+			case record.Part != nil:
+				if err := t.StorePart(record.Part); err != nil {
+					return err
+				}
+
+				restored++
+			case record.Bike != nil:
+				bikes = append(bikes, record.Bike)
+				if len(bikes) < Limit {
+					continue
+				}
+
+				if err := t.StoreBikes(bikes); err != nil {
+					return err
+				}
+
+				restored += len(bikes)
+				bikes = bikes[:0]
+			}
 		}
 
-		if err := fsm.BikeStore.StoreBike(&bike); err != nil {
+		if err := t.StoreBikes(bikes); err != nil {
 			return err
 		}
 
-		restored++
+		restored += len(bikes)
+
+		if reader.Version > 0 && counters[AppliedIndexCounter] != reader.AppliedIndex {
+			return fmt.Errorf("%w: applied index %d in header, %d in counters", ErrCorruptedSnapshot, reader.AppliedIndex, counters[AppliedIndexCounter])
+		}
+
+		return nil
+	})
+	if err != nil {
+		return err
 	}
 
-	log.Printf("[RESTORE] restored=%d", restored)
+	fsm.AppliedIndex.Store(counters[AppliedIndexCounter])
+
+	log.Printf("[RESTORE] version=%d compression=%s restored=%d applied_index=%d", reader.Version, reader.Compression, restored, counters[AppliedIndexCounter])
 
 	return nil
 }
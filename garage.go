@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+)
+
+// DefaultGarage is the garage of the bikes reached without the /garages/{garage} prefix and of the bikes created before garages existed.
+const DefaultGarage = "default"
+
+// ErrQuotaExceeded is returned when a garage already holds as many bikes as its quota allows.
+var ErrQuotaExceeded = errors.New("garage quota exceeded")
+
+// Garage is a namespace of bikes, MaxBikes limits its number of bikes when it is not 0.
+type Garage struct {
+	Name     string `json:"name"`
+	MaxBikes uint64 `json:"max_bikes"`
+	Bikes    uint64 `json:"bikes"`
+}
+
+type garageKey struct{}
+
+// WithGarage returns a copy of ctx carrying the garage.
+func WithGarage(ctx context.Context, garage string) context.Context {
+	return context.WithValue(ctx, garageKey{}, garage)
+}
+
+// GarageFrom returns the garage carried by ctx, the default garage when there is none.
+func GarageFrom(ctx context.Context) string {
+	garage, _ := ctx.Value(garageKey{}).(string)
+	if garage == "" {
+		return DefaultGarage
+	}
+
+	return garage
+}
+
+// GetGarages selects the garages holding bikes or having a quota from database.
+func (bs *BikeStore) GetGarages(garages *[]*Garage) error {
+	rows, err := bs.DB.Query(`SELECT names.name, COALESCE(garage.max_bikes, 0), (SELECT COUNT(*) FROM bike WHERE bike.garage = names.name)
+		FROM (SELECT name FROM garage UNION SELECT DISTINCT garage FROM bike) AS names LEFT JOIN garage ON garage.name = names.name ORDER BY names.name`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		garage := Garage{}
+
+		if err := rows.Scan(&garage.Name, &garage.MaxBikes, &garage.Bikes); err != nil {
+			return err
+		}
+
+		*garages = append(*garages, &garage)
+	}
+
+	return rows.Err()
+}
+
+// GetGarage gets a garage from database, a garage without quota nor bikes is empty.
+func (bs *BikeStore) GetGarage(name string, garage *Garage) error {
+	return getGarage(bs.DB, name, garage)
+}
+
+func getQuotas(q queryer, garages *[]*Garage) error {
+	rows, err := q.Query("SELECT name, max_bikes FROM garage ORDER BY name")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		garage := Garage{}
+
+		if err := rows.Scan(&garage.Name, &garage.MaxBikes); err != nil {
+			return err
+		}
+
+		*garages = append(*garages, &garage)
+	}
+
+	return rows.Err()
+}
+
+// CheckGarage checks that a bike belongs to a garage, sql.ErrNoRows is returned otherwise.
+func (bs *BikeStore) CheckGarage(garage string, id uint64) error {
+	return checkGarage(bs.DB, garage, id)
+}
+
+// SetGarage sets the quota of a garage.
+func (t *BikeTx) SetGarage(garage *Garage) error {
+	_, err := t.tx.Exec("INSERT OR REPLACE INTO garage(name, max_bikes) VALUES(?, ?)", garage.Name, garage.MaxBikes)
+	return err
+}
+
+// DeleteGarage deletes the bikes of a garage, their components and its quota.
+func (t *BikeTx) DeleteGarage(name string) error {
+	for _, query := range []string{
+		"DELETE FROM component WHERE bike_rowid IN (SELECT rowid FROM bike WHERE garage = ?)",
+		"DELETE FROM bike WHERE garage = ?",
+		"DELETE FROM garage WHERE name = ?",
+	} {
+		if _, err := t.tx.Exec(query, name); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// CheckGarage checks that a bike belongs to a garage within the transaction.
+func (t *BikeTx) CheckGarage(garage string, id uint64) error {
+	return checkGarage(t.tx, garage, id)
+}
+
+// CheckQuota checks that one more bike fits in a garage.
+func (t *BikeTx) CheckQuota(name string) error {
+	garage := Garage{}
+	if err := getGarage(t.tx, name, &garage); err != nil {
+		return err
+	}
+
+	if garage.MaxBikes != 0 && garage.Bikes >= garage.MaxBikes {
+		return ErrQuotaExceeded
+	}
+
+	return nil
+}
+
+func getGarage(q queryer, name string, garage *Garage) error {
+	garage.Name = name
+
+	return q.QueryRow("SELECT COALESCE((SELECT max_bikes FROM garage WHERE name = ?), 0), (SELECT COUNT(*) FROM bike WHERE garage = ?)", name, name).Scan(&garage.MaxBikes, &garage.Bikes)
+}
+
+func checkGarage(q queryer, garage string, id uint64) error {
+	exists := false
+	if err := q.QueryRow("SELECT EXISTS(SELECT 1 FROM bike WHERE rowid = ? AND garage = ?)", id, garage).Scan(&exists); err != nil {
+		return err
+	}
+
+	if !exists {
+		return sql.ErrNoRows
+	}
+
+	return nil
+}
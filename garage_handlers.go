@@ -0,0 +1,196 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"strconv"
+
+	"github.com/hashicorp/raft"
+)
+
+// GetGaragesHandler is a REST handler.
+type GetGaragesHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /garages.
+func (h *GetGaragesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	garages := []*Garage{}
+	if err := h.Application.BikeStore.GetGarages(&garages); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, garages)
+}
+
+// GetGarageHandler is a REST handler.
+type GetGarageHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /garages/:garage.
+func (h *GetGarageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	garage := Garage{}
+	if err := h.Application.BikeStore.GetGarage(GarageFrom(r.Context()), &garage); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &garage)
+}
+
+// PutGarageHandler is a REST handler.
+type PutGarageHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles PUT /garages/:garage, it sets the quota of the garage.
+func (h *PutGarageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	garage := Garage{}
+	if err := json.Unmarshal(body, &garage); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), SetGarageOp, &garage)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := h.Application.BikeStore.GetGarage(GarageFrom(r.Context()), &garage); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &garage)
+}
+
+// DeleteGarageHandler is a REST handler.
+type DeleteGarageHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles DELETE /garages/:garage, it deletes every bike of the garage.
+func (h *DeleteGarageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), DeleteGarageOp, &Garage{
+		Name: GarageFrom(r.Context()),
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// ExportGarageHandler is a REST handler.
+type ExportGarageHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /garages/:garage/export, the bikes of the garage are streamed as a JSON array
+// by ascending ID, from a view of the bike store so that concurrent writes do not shift the pages.
+func (h *ExportGarageHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	garage := GarageFrom(r.Context())
+
+	view, err := h.Application.BikeStore.View()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	defer view.Release()
+
+	bikes := []*Bike{}
+	if err := view.GetBikesAfter(garage, 0, Limit, &bikes); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+
+	encoder := json.NewEncoder(w)
+
+	io.WriteString(w, "[")
+	for first := true; len(bikes) > 0; {
+		after := bikes[len(bikes)-1].ID
+
+		for _, bike := range bikes {
+			if !first {
+				io.WriteString(w, ",")
+			}
+
+			first = false
+
+			if err := encoder.Encode(bike); err != nil {
+				return
+			}
+		}
+
+		if len(bikes) < Limit {
+			break
+		}
+
+		bikes = bikes[:0]
+		if err := view.GetBikesAfter(garage, after, Limit, &bikes); err != nil {
+			// The status is already sent, the truncated array tells the client the export failed.
+			return
+		}
+	}
+	io.WriteString(w, "]")
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+const (
+	// DefaultCadence is the cadence in rpm used to compute speeds.
+	DefaultCadence = 90.0
+
+	// DefaultTireWidth is the tire width in mm used when no component gives it.
+	DefaultTireWidth = 28.0
+
+	// OverlapThreshold is the relative ratio difference under which two gears overlap.
+	OverlapThreshold = 0.03
+)
+
+var (
+	// ErrIncompleteDrivetrain is returned when the gearing of a bike cannot be computed.
+	ErrIncompleteDrivetrain = errors.New("incomplete drivetrain")
+
+	// ErrInvalidSpec is returned when a spec used by the gearing cannot be parsed.
+	ErrInvalidSpec = errors.New("invalid spec")
+)
+
+// WheelSizes maps common wheel sizes to their ISO bead seat diameter in mm.
+var WheelSizes = map[string]float64{
+	"700c": 622,
+	"29":   622,
+	"650b": 584,
+	"27.5": 584,
+	"26":   559,
+	"24":   507,
+	"20":   406,
+}
+
+// Gear is a chainring and cog combination, development is in meters and speed in km/h.
+type Gear struct {
+	Chainring   int     `json:"chainring"`
+	Cog         int     `json:"cog"`
+	Ratio       float64 `json:"ratio"`
+	GearInches  float64 `json:"gear_inches"`
+	Development float64 `json:"development"`
+	Speed       float64 `json:"speed"`
+	Duplicate   bool    `json:"duplicate"`
+	Overlap     bool    `json:"overlap"`
+}
+
+// Gearing is the gear table of a bike sorted by ratio, the wheel diameter is in mm.
+type Gearing struct {
+	Cadence       float64 `json:"cadence"`
+	WheelDiameter float64 `json:"wheel_diameter"`
+	Gears         []*Gear `json:"gears"`
+}
+
+// GetGearing computes the gearing of a bike from database.
+func (bs *BikeStore) GetGearing(id uint64, cadence float64, gearing *Gearing) error {
+	bike := Bike{}
+	if err := bs.GetBike(id, &bike); err != nil {
+		return err
+	}
+
+	g, err := NewGearing(bike.Components, cadence)
+	if err != nil {
+		return err
+	}
+
+	*gearing = *g
+
+	return nil
+}
+
+// NewGearing computes the gearing from the chainrings and cassette specs of the drivetrain
+// and the wheel_size and tire_width specs of the wheelset or tires.
+func NewGearing(components []*Component, cadence float64) (*Gearing, error) {
+	chainrings, cogs := []int{}, []int{}
+	bsd, tireWidth := 0.0, DefaultTireWidth
+
+	for _, component := range components {
+		switch component.Category {
+		case "drivetrain":
+			if v, ok := component.Specs["chainrings"]; ok {
+				teeth, err := parseTeeth(v)
+				if err != nil {
+					return nil, err
+				}
+				chainrings = append(chainrings, teeth...)
+			}
+
+			if v, ok := component.Specs["cassette"]; ok {
+				teeth, err := parseTeeth(v)
+				if err != nil {
+					return nil, err
+				}
+				cogs = append(cogs, teeth...)
+			}
+		case "wheelset", "tires":
+			if v, ok := component.Specs["wheel_size"]; ok {
+				d, err := parseWheelSize(v)
+				if err != nil {
+					return nil, err
+				}
+				bsd = d
+			}
+
+			if v, ok := component.Specs["tire_width"]; ok {
+				w, err := parsePositive(v)
+				if err != nil {
+					return nil, fmt.Errorf("%w: tire width %q", ErrInvalidSpec, v)
+				}
+				tireWidth = w
+			}
+		}
+	}
+
+	if len(chainrings) == 0 || len(cogs) == 0 || bsd == 0 {
+		return nil, fmt.Errorf("%w: chainrings, cassette and wheel_size specs are required", ErrIncompleteDrivetrain)
+	}
+
+	gearing := &Gearing{
+		Cadence:       cadence,
+		WheelDiameter: bsd + 2*tireWidth,
+		Gears:         []*Gear{},
+	}
+
+	for _, chainring := range chainrings {
+		for _, cog := range cogs {
+			ratio := float64(chainring) / float64(cog)
+			development := ratio * math.Pi * gearing.WheelDiameter / 1000
+
+			gearing.Gears = append(gearing.Gears, &Gear{
+				Chainring:   chainring,
+				Cog:         cog,
+				Ratio:       round(ratio),
+				GearInches:  round(ratio * gearing.WheelDiameter / 25.4),
+				Development: round(development),
+				Speed:       round(development * cadence * 60 / 1000),
+			})
+		}
+	}
+
+	sort.SliceStable(gearing.Gears, func(i, j int) bool {
+		return gearing.Gears[i].Ratio < gearing.Gears[j].Ratio
+	})
+
+	for i, gear := range gearing.Gears {
+		for j, other := range gearing.Gears {
+			if i == j {
+				continue
+			}
+
+			// Cogs of a same chainring always overlap, only identical cogs are duplicates.
+			if gear.Chainring*other.Cog == other.Chainring*gear.Cog {
+				gear.Duplicate = true
+			} else if gear.Chainring != other.Chainring && math.Abs(gear.Ratio-other.Ratio)/gear.Ratio < OverlapThreshold {
+				gear.Overlap = true
+			}
+		}
+	}
+
+	return gearing, nil
+}
+
+func parseTeeth(s string) ([]int, error) {
+	teeth := []int{}
+
+	for _, f := range strings.FieldsFunc(s, func(r rune) bool {
+		return r == ',' || r == '-' || r == '/' || r == ' '
+	}) {
+		t, err := strconv.Atoi(f)
+		if err != nil || t <= 0 {
+			return nil, fmt.Errorf("%w: tooth count %q", ErrInvalidSpec, f)
+		}
+
+		teeth = append(teeth, t)
+	}
+
+	return teeth, nil
+}
+
+func parseWheelSize(s string) (float64, error) {
+	if d, ok := WheelSizes[strings.ToLower(s)]; ok {
+		return d, nil
+	}
+
+	d, err := parsePositive(s)
+	if err != nil {
+		return 0, fmt.Errorf("%w: wheel size %q", ErrInvalidSpec, s)
+	}
+
+	return d, nil
+}
+
+// parsePositive parses a finite positive number.
+func parsePositive(s string) (float64, error) {
+	f, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		return 0, err
+	}
+
+	if f <= 0 || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, errors.New("not a positive number")
+	}
+
+	return f, nil
+}
+
+func round(f float64) float64 {
+	return math.Round(f*100) / 100
+}
@@ -9,6 +9,7 @@ require (
 	github.com/hashicorp/go-uuid v1.0.1 // indirect
 	github.com/hashicorp/golang-lru v0.5.1 // indirect
 	github.com/hashicorp/raft v1.2.0
+	github.com/klauspost/compress v1.15.9
 	github.com/mattn/go-sqlite3 v1.14.6
 	golang.org/x/sys v0.0.0-20200202164722-d101bd2416d5 // indirect
 )
@@ -4,12 +4,12 @@ import (
 	"bytes"
 	"database/sql"
 	"encoding/json"
-	"fmt"
+	"errors"
 	"html/template"
 	"io"
+	"math"
 	"net/http"
 	"strconv"
-	"strings"
 
 	"github.com/gorilla/mux"
 	"github.com/hashicorp/raft"
@@ -43,14 +43,19 @@ func (h *IndexHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		Bikes:  []*Bike{},
 	}
 
-	if err := h.Application.BikeStore.GetBikes(limit, offset, &data.Bikes); err != nil {
+	if err := h.Application.BikeStore.GetBikes(DefaultGarage, limit, offset, &data.Bikes); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, err.Error())
 		return
 	}
 
+	for _, bike := range data.Bikes {
+		bike.Summary = NewSummary(bike.Components)
+		bike.Gearing, _ = NewGearing(bike.Components, DefaultCadence)
+	}
+
 	w.WriteHeader(http.StatusOK)
-	h.Template.Execute(w, data)
+	h.Template.ExecuteTemplate(w, "index.tmpl", data)
 }
 
 // GetBikesHandler is a REST handler.
@@ -60,6 +65,10 @@ type GetBikesHandler struct {
 
 // ServeHTTP handles GET /bikes.
 func (h *GetBikesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
 	limit, err := strconv.ParseUint(r.URL.Query().Get("limit"), 10, 64)
 	if err != nil {
 		w.WriteHeader(http.StatusBadRequest)
@@ -75,7 +84,7 @@ func (h *GetBikesHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	}
 
 	bikes := []*Bike{}
-	if err := h.Application.BikeStore.GetBikes(limit, offset, &bikes); err != nil {
+	if err := h.Application.BikeStore.GetBikes(GarageFrom(r.Context()), limit, offset, &bikes); err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
 		io.WriteString(w, err.Error())
 		return
@@ -100,6 +109,10 @@ type GetBikeHandler struct {
 
 // ServeHTTP handles GET /bikes/:id.
 func (h *GetBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
 	vars := mux.Vars(r)
 
 	id, err := strconv.ParseUint(vars["id"], 10, 64)
@@ -109,6 +122,11 @@ func (h *GetBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	if err := h.Application.BikeStore.CheckGarage(GarageFrom(r.Context()), id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
 	bike := Bike{}
 	if err := h.Application.BikeStore.GetBike(id, &bike); err != nil {
 		if err == sql.ErrNoRows {
@@ -120,6 +138,8 @@ func (h *GetBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	bike.Summary = NewSummary(bike.Components)
+
 	resp, err := json.Marshal(bike)
 	if err != nil {
 		w.WriteHeader(http.StatusInternalServerError)
@@ -132,6 +152,37 @@ func (h *GetBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	io.WriteString(w, string(resp))
 }
 
+// GetSummaryHandler is a REST handler.
+type GetSummaryHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /bikes/:id/summary.
+func (h *GetSummaryHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.BikeStore.CheckGarage(GarageFrom(r.Context()), id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	summary := Summary{}
+	if err := h.Application.BikeStore.GetSummary(id, &summary); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &summary)
+}
+
 // PostBikesHandler is a REST handler.
 type PostBikeHandler struct {
 	Application *Application
@@ -141,64 +192,851 @@ type PostBikeHandler struct {
 func (h *PostBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
 	body, err := io.ReadAll(r.Body)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
-		io.WriteString(w, err.Error())
+		writeError(w, http.StatusInternalServerError, err)
 		return
 	}
 
-	if h.Application.Cluster.State() == raft.Leader {
-		apply := h.Application.Cluster.Apply(body, 0)
-		if err := apply.Error(); err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), BuilderRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	bike := Bike{}
+	if err := json.Unmarshal(body, &bike); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.BikeStore.ResolveParts(bike.Components); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := bike.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	issues := CheckCompatibility(bike.Components)
+	if HasErrors(issues) {
+		writeJSON(w, http.StatusUnprocessableEntity, issues)
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), CreateBikeOp, &bike)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	applyResponse.Bike.Compatibility = issues
+
+	writeJSON(w, http.StatusOK, applyResponse.Bike)
+}
+
+// PutBikeHandler is a REST handler.
+type PutBikeHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles PUT /bikes/:id.
+func (h *PutBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.AuthorizeBike(r.Context(), id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	bike := Bike{}
+	if err := json.Unmarshal(body, &bike); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.BikeStore.ResolveParts(bike.Components); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	if err := bike.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	issues := CheckCompatibility(bike.Components)
+	if HasErrors(issues) {
+		writeJSON(w, http.StatusUnprocessableEntity, issues)
+		return
+	}
+
+	bike.ID = id
+
+	applyResponse, err := h.Application.Apply(r.Context(), UpdateBikeOp, &bike)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	applyResponse.Bike.Compatibility = issues
+
+	writeJSON(w, http.StatusOK, applyResponse.Bike)
+}
+
+// PatchBikeHandler is a REST handler.
+type PatchBikeHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles PATCH /bikes/:id.
+func (h *PatchBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.AuthorizeBike(r.Context(), id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	patch := BikePatch{}
+	if err := json.Unmarshal(body, &patch); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	issues := []*Issue(nil)
+	if patch.Components != nil {
+		bike := Bike{
+			Components: *patch.Components,
+		}
+
+		if err := h.Application.BikeStore.ResolveParts(bike.Components); err != nil {
+			writeError(w, statusOf(err), err)
 			return
 		}
 
-		applyResponse := apply.Response()
-		if err := applyResponse.(*ApplyResponse).Err; err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
+		if err := bike.Validate(); err != nil {
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		resp, err := json.Marshal(applyResponse.(*ApplyResponse).Bike)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
+		issues = CheckCompatibility(bike.Components)
+		if HasErrors(issues) {
+			writeJSON(w, http.StatusUnprocessableEntity, issues)
 			return
 		}
+	}
+
+	patch.ID = id
+
+	applyResponse, err := h.Application.Apply(r.Context(), PatchBikeOp, &patch)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	applyResponse.Bike.Compatibility = issues
+
+	writeJSON(w, http.StatusOK, applyResponse.Bike)
+}
+
+// DeleteBikeHandler is a REST handler.
+type DeleteBikeHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles DELETE /bikes/:id.
+func (h *DeleteBikeHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), DeleteBikeOp, &BikeKey{
+		ID: id,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// PostComponentHandler is a REST handler.
+type PostComponentHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /bikes/:id/components.
+func (h *PostComponentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	bikeID, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusOK)
-		io.WriteString(w, string(resp))
-	} else {
-		leader := strings.Split(string(h.Application.Cluster.Leader()), ":")
-		req, err := http.NewRequest(http.MethodPost, fmt.Sprintf("http://%s:%d%s", leader[0], h.Application.Config.APIPort, r.URL.Path), bytes.NewBuffer(body))
+	if err := h.Application.AuthorizeBike(r.Context(), bikeID); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	component := Component{}
+	if err := json.Unmarshal(body, &component); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := component.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	component.ID = 0
+	component.BikeID = bikeID
+
+	if !h.Application.checkBuild(w, bikeID, &component) {
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), CreateComponentOp, &component)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, applyResponse.Component)
+}
+
+// PutComponentHandler is a REST handler.
+type PutComponentHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles PUT /bikes/:id/components/:cid.
+func (h *PutComponentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	bikeID, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(vars["cid"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.AuthorizeBike(r.Context(), bikeID); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	component := Component{}
+	if err := json.Unmarshal(body, &component); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := component.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	component.ID = id
+	component.BikeID = bikeID
+
+	if !h.Application.checkBuild(w, bikeID, &component) {
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), UpdateComponentOp, &component)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, applyResponse.Component)
+}
+
+// DeleteComponentHandler is a REST handler.
+type DeleteComponentHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles DELETE /bikes/:id/components/:cid.
+func (h *DeleteComponentHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	vars := mux.Vars(r)
+
+	bikeID, err := strconv.ParseUint(vars["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	id, err := strconv.ParseUint(vars["cid"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.AuthorizeBike(r.Context(), bikeID); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), DeleteComponentOp, &ComponentKey{
+		BikeID: bikeID,
+		ID:     id,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// GetCompatibilityHandler is a REST handler.
+type GetCompatibilityHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /bikes/:id/compatibility.
+func (h *GetCompatibilityHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.BikeStore.CheckGarage(GarageFrom(r.Context()), id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	bike := Bike{}
+	if err := h.Application.BikeStore.GetBike(id, &bike); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, CheckCompatibility(bike.Components))
+}
+
+// checkBuild checks the components of a bike once a component is added or replaced,
+// it writes the issues and returns false when the component does not fit.
+func (app *Application) checkBuild(w http.ResponseWriter, bikeID uint64, component *Component) bool {
+	if err := app.BikeStore.ResolveParts([]*Component{component}); err != nil {
+		writeError(w, statusOf(err), err)
+		return false
+	}
+
+	bike := Bike{}
+	if err := app.BikeStore.GetBike(bikeID, &bike); err != nil {
+		if err == sql.ErrNoRows {
+			return true
+		}
+
+		writeError(w, http.StatusInternalServerError, err)
+		return false
+	}
+
+	components := []*Component{component}
+	for _, c := range bike.Components {
+		if c.ID != component.ID {
+			components = append(components, c)
+		}
+	}
+
+	if issues := CheckCompatibility(components); HasErrors(issues) {
+		writeJSON(w, http.StatusUnprocessableEntity, issues)
+		return false
+	}
+
+	return true
+}
+
+// GetGearingHandler is a REST handler.
+type GetGearingHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /bikes/:id/gearing.
+func (h *GetGearingHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := h.Application.BikeStore.CheckGarage(GarageFrom(r.Context()), id); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	cadence := DefaultCadence
+	if v := r.URL.Query().Get("cadence"); v != "" {
+		cadence, err = strconv.ParseFloat(v, 64)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
+			writeError(w, http.StatusBadRequest, err)
 			return
 		}
 
-		client := http.Client{}
-		resp, err := client.Do(req)
-		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
+		if cadence <= 0 || math.IsNaN(cadence) || math.IsInf(cadence, 0) {
+			writeError(w, http.StatusBadRequest, errors.New("cadence must be a positive number"))
 			return
 		}
+	}
+
+	gearing := Gearing{}
+	if err := h.Application.BikeStore.GetGearing(id, cadence, &gearing); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
 
-		defer resp.Body.Close()
+	writeJSON(w, http.StatusOK, &gearing)
+}
+
+// GetPartsHandler is a REST handler.
+type GetPartsHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /catalog.
+func (h *GetPartsHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	limit, err := strconv.ParseUint(r.URL.Query().Get("limit"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
 
-		respBody, err := io.ReadAll(resp.Body)
+	offset, err := strconv.ParseUint(r.URL.Query().Get("offset"), 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	parts := []*Part{}
+	if err := h.Application.BikeStore.GetParts(limit, offset, &parts); err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, parts)
+}
+
+// GetPartHandler is a REST handler.
+type GetPartHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles GET /catalog/:id.
+func (h *GetPartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.Application.Consistent(w, r) {
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	part := Part{}
+	if err := h.Application.BikeStore.GetPart(id, &part); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, &part)
+}
+
+// PostPartHandler is a REST handler.
+type PostPartHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles POST /catalog.
+func (h *PostPartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	part := Part{}
+	if err := json.Unmarshal(body, &part); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := part.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	part.ID = 0
+
+	applyResponse, err := h.Application.Apply(r.Context(), CreatePartOp, &part)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, applyResponse.Part)
+}
+
+// PutPartHandler is a REST handler.
+type PutPartHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles PUT /catalog/:id.
+func (h *PutPartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, body)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	part := Part{}
+	if err := json.Unmarshal(body, &part); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	if err := part.Validate(); err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	part.ID = id
+
+	applyResponse, err := h.Application.Apply(r.Context(), UpdatePartOp, &part)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, applyResponse.Part)
+}
+
+// DeletePartHandler is a REST handler.
+type DeletePartHandler struct {
+	Application *Application
+}
+
+// ServeHTTP handles DELETE /catalog/:id.
+func (h *DeletePartHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if h.Application.Cluster.State() != raft.Leader {
+		h.Application.Forward(w, r, nil)
+		return
+	}
+
+	if err := Authorize(r.Context(), AdminRole); err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	id, err := strconv.ParseUint(mux.Vars(r)["id"], 10, 64)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	applyResponse, err := h.Application.Apply(r.Context(), DeletePartOp, &PartKey{
+		ID: id,
+	})
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set(RaftIndexHeader, strconv.FormatUint(applyResponse.Index, 10))
+
+	if err := applyResponse.Err; err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Forward sends the request with its method, headers and query to the API address published by the leader and copies its response.
+func (app *Application) Forward(w http.ResponseWriter, r *http.Request, body []byte) {
+	leader, err := app.LeaderAPIAddress()
+	if err != nil {
+		writeError(w, statusOf(err), err)
+		return
+	}
+
+	url := app.apiURL(leader, r.URL.Path)
+	if r.URL.RawQuery != "" {
+		url += "?" + r.URL.RawQuery
+	}
+
+	req, err := http.NewRequest(r.Method, url, bytes.NewBuffer(body))
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	req.Header = r.Header.Clone()
+	req.Header.Del(ForwardedPrincipalHeader)
+
+	// The request is authenticated as the node, on behalf of its principal, so that principals
+	// authenticated by a client certificate also survive the forwarding.
+	if principal := PrincipalFrom(r.Context()); principal != nil {
+		value, err := EncodePrincipal(principal)
 		if err != nil {
-			w.WriteHeader(http.StatusInternalServerError)
-			io.WriteString(w, err.Error())
+			writeError(w, http.StatusInternalServerError, err)
 			return
 		}
 
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(resp.StatusCode)
-		io.WriteString(w, string(respBody))
+		req.Header.Del("Authorization")
+		req.Header.Set(APIKeyHeader, app.Config.Auth.NodeAPIKey)
+		req.Header.Set(ForwardedPrincipalHeader, value)
+	}
+
+	resp, err := app.Client.Do(req)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
 	}
+
+	defer resp.Body.Close()
+
+	respBody, err := io.ReadAll(resp.Body)
+	if err != nil {
+		writeError(w, http.StatusBadGateway, err)
+		return
+	}
+
+	for name, values := range resp.Header {
+		w.Header()[name] = values
+	}
+
+	w.WriteHeader(resp.StatusCode)
+	w.Write(respBody)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	resp, err := json.Marshal(v)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(resp)
+}
+
+func writeError(w http.ResponseWriter, status int, err error) {
+	w.WriteHeader(status)
+	io.WriteString(w, err.Error())
+}
+
+func statusOf(err error) int {
+	if err == sql.ErrNoRows {
+		return http.StatusNotFound
+	}
+
+	if errors.Is(err, ErrIncompleteDrivetrain) || errors.Is(err, ErrInvalidSpec) || errors.Is(err, ErrUnknownCatalogPart) {
+		return http.StatusUnprocessableEntity
+	}
+
+	if err == ErrCatalogPartInUse || err == ErrQuotaExceeded {
+		return http.StatusConflict
+	}
+
+	if errors.Is(err, ErrForbidden) {
+		return http.StatusForbidden
+	}
+
+	if err == ErrUnknownServer {
+		return http.StatusNotFound
+	}
+
+	if err == ErrNoLeader || err == ErrUnknownLeaderAddress || err == ErrReadTimeout || err == raft.ErrNotLeader || err == raft.ErrLeadershipLost {
+		return http.StatusServiceUnavailable
+	}
+
+	return http.StatusInternalServerError
 }
@@ -12,6 +12,7 @@ import (
 	"net/http"
 	"os"
 	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -20,30 +21,42 @@ import (
 
 // Configuration is used to load the configuration file.
 type Configuration struct {
-	LocalID                  string        `json:"local_id"`
-	Hostname                 string        `json:"hostname"`
-	TrailingLogs             uint64        `json:"trailing_logs"`
-	LogStoreFile             string        `json:"log_store_file"`
-	LogCacheSize             int           `json:"log_cache_size"`
-	SnapshotDir              string        `json:"snapshot_dir"`
-	SnapshotInterval         string        `json:"snapshot_interval"`
-	SnapshotThreshold        uint64        `json:"snapshot_threshold"`
-	SnapshotRetain           int           `json:"snapshot_retain"`
-	NoSnapshotRestoreOnStart bool          `json:"no_snapshot_restore_on_start"`
-	RAFTPort                 int           `json:"raft_port"`
-	MaxPool                  int           `json:"max_pool"`
-	TCPTimeout               string        `json:"tcp_timeout"`
-	Servers                  []raft.Server `json:"servers"`
-	BikeStoreFile            string        `json:"bike_store_file"`
-	APIPort                  int           `json:"api_port"`
-	Graceful                 string        `json:"graceful"`
+	LocalID                  string             `json:"local_id"`
+	Hostname                 string             `json:"hostname"`
+	TrailingLogs             uint64             `json:"trailing_logs"`
+	LogStoreFile             string             `json:"log_store_file"`
+	LogCacheSize             int                `json:"log_cache_size"`
+	SnapshotDir              string             `json:"snapshot_dir"`
+	SnapshotInterval         string             `json:"snapshot_interval"`
+	SnapshotThreshold        uint64             `json:"snapshot_threshold"`
+	SnapshotRetain           int                `json:"snapshot_retain"`
+	NoSnapshotRestoreOnStart bool               `json:"no_snapshot_restore_on_start"`
+	SnapshotCompression      string             `json:"snapshot_compression"`
+	RAFTPort                 int                `json:"raft_port"`
+	MaxPool                  int                `json:"max_pool"`
+	TCPTimeout               string             `json:"tcp_timeout"`
+	Servers                  []raft.Server      `json:"servers"`
+	BikeStoreFile            string             `json:"bike_store_file"`
+	APIPort                  int                `json:"api_port"`
+	APIAddress               string             `json:"api_address"`
+	Graceful                 string             `json:"graceful"`
+	Seeds                    []string           `json:"seeds"`
+	Nonvoter                 bool               `json:"nonvoter"`
+	JoinRetry                string             `json:"join_retry"`
+	ReadTimeout              string             `json:"read_timeout"`
+	LeaveOnShutdown          bool               `json:"leave_on_shutdown"`
+	RaftTLS                  *TLSConfiguration  `json:"raft_tls"`
+	APITLS                   *TLSConfiguration  `json:"api_tls"`
+	Auth                     *AuthConfiguration `json:"auth"`
 }
 
 // Application gives access to the configuration, the Raft cluster and the bike store.
 type Application struct {
-	Config    *Configuration
-	Cluster   *raft.Raft
-	BikeStore *BikeStore
+	Config       *Configuration
+	Cluster      *raft.Raft
+	BikeStore    *BikeStore
+	AppliedIndex *AppliedIndex
+	Client       *http.Client
 }
 
 var (
@@ -81,6 +94,8 @@ func main() {
 			TCPTimeout:               "1s",
 			APIPort:                  8001,
 			Graceful:                 "5s",
+			JoinRetry:                "2s",
+			ReadTimeout:              "5s",
 		},
 	}
 
@@ -93,6 +108,10 @@ func main() {
 		log.Fatal(err)
 	}
 
+	if app.Config.APIAddress == "" {
+		app.Config.APIAddress = fmt.Sprintf("%s:%d", app.Config.Hostname, app.Config.APIPort)
+	}
+
 	app.BikeStore, err = NewBikeStore(app.Config.BikeStoreFile)
 	if err != nil {
 		log.Fatal(err)
@@ -131,16 +150,47 @@ func main() {
 		log.Fatal(err)
 	}
 
-	transport, err := raft.NewTCPTransportWithLogger(bindAddr, advertise, app.Config.MaxPool, parseDuration(app.Config.TCPTimeout), raftConfig.Logger)
+	reloaders := []*CertificateReloader{}
+
+	var transport *raft.NetworkTransport
+	if app.Config.RaftTLS != nil {
+		reloader, err := NewCertificateReloader(app.Config.RaftTLS.CertFile, app.Config.RaftTLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		reloaders = append(reloaders, reloader)
+
+		tlsConfig, err := app.Config.RaftTLS.NewTLSConfig(reloader)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		stream, err := NewTLSStreamLayer(bindAddr, advertise, tlsConfig)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		transport = raft.NewNetworkTransportWithLogger(stream, app.Config.MaxPool, parseDuration(app.Config.TCPTimeout), raftConfig.Logger)
+	} else {
+		transport, err = raft.NewTCPTransportWithLogger(bindAddr, advertise, app.Config.MaxPool, parseDuration(app.Config.TCPTimeout), raftConfig.Logger)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	compression, err := ParseSnapshotCompression(app.Config.SnapshotCompression)
 	if err != nil {
 		log.Fatal(err)
 	}
 
-	fsm, err := NewFSM(app.BikeStore)
+	fsm, err := NewFSM(app.BikeStore, compression)
 	if err != nil {
 		log.Fatal(err)
 	}
 
+	app.AppliedIndex = fsm.AppliedIndex
+
 	app.Cluster, err = raft.NewRaft(raftConfig, fsm, cacheStore, logStore, snapshotStore, transport)
 	if err != nil {
 		log.Fatal(err)
@@ -165,21 +215,147 @@ func main() {
 	r := mux.NewRouter()
 	r.Use(Logger)
 	r.Use(CORS)
+	r.Use(Garages)
+
+	if app.Config.Auth != nil {
+		authenticator, err := NewAuthenticator(app.Config.Auth)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		r.Use(Authentication(authenticator))
+	}
 
 	r.Handle("/", &IndexHandler{
 		Application: app,
 		Template:    tmpl,
 	}).Methods(http.MethodGet)
 
-	r.Handle("/bikes", &GetBikesHandler{
+	r.Handle("/garages", &GetGaragesHandler{
+		Application: app,
+	}).Methods(http.MethodGet)
+
+	r.Handle("/garages/{garage:[a-zA-Z0-9_-]+}", &GetGarageHandler{
+		Application: app,
+	}).Methods(http.MethodGet)
+
+	r.Handle("/garages/{garage:[a-zA-Z0-9_-]+}", &PutGarageHandler{
+		Application: app,
+	}).Methods(http.MethodPut)
+
+	r.Handle("/garages/{garage:[a-zA-Z0-9_-]+}", &DeleteGarageHandler{
+		Application: app,
+	}).Methods(http.MethodDelete)
+
+	// Bike routes are served for the default garage and within /garages/{garage}.
+	for _, router := range []*mux.Router{r, r.PathPrefix("/garages/{garage:[a-zA-Z0-9_-]+}").Subrouter()} {
+		router.Handle("/bikes", &GetBikesHandler{
+			Application: app,
+		}).Methods(http.MethodGet)
+
+		router.Handle("/bikes/{id:[0-9]+}", &GetBikeHandler{
+			Application: app,
+		}).Methods(http.MethodGet)
+
+		router.Handle("/bikes/{id:[0-9]+}/summary", &GetSummaryHandler{
+			Application: app,
+		}).Methods(http.MethodGet)
+
+		router.Handle("/bikes/{id:[0-9]+}/compatibility", &GetCompatibilityHandler{
+			Application: app,
+		}).Methods(http.MethodGet)
+
+		router.Handle("/bikes/{id:[0-9]+}/gearing", &GetGearingHandler{
+			Application: app,
+		}).Methods(http.MethodGet)
+
+		router.Handle("/bikes", &PostBikeHandler{
+			Application: app,
+		}).Methods(http.MethodPost)
+
+		router.Handle("/bikes/{id:[0-9]+}", &PutBikeHandler{
+			Application: app,
+		}).Methods(http.MethodPut)
+
+		router.Handle("/bikes/{id:[0-9]+}", &PatchBikeHandler{
+			Application: app,
+		}).Methods(http.MethodPatch)
+
+		router.Handle("/bikes/{id:[0-9]+}", &DeleteBikeHandler{
+			Application: app,
+		}).Methods(http.MethodDelete)
+
+		router.Handle("/bikes/{id:[0-9]+}/components", &PostComponentHandler{
+			Application: app,
+		}).Methods(http.MethodPost)
+
+		router.Handle("/bikes/{id:[0-9]+}/components/{cid:[0-9]+}", &PutComponentHandler{
+			Application: app,
+		}).Methods(http.MethodPut)
+
+		router.Handle("/bikes/{id:[0-9]+}/components/{cid:[0-9]+}", &DeleteComponentHandler{
+			Application: app,
+		}).Methods(http.MethodDelete)
+
+		router.Handle("/export", &ExportGarageHandler{
+			Application: app,
+		}).Methods(http.MethodGet)
+	}
+
+	r.Handle("/catalog", &GetPartsHandler{
+		Application: app,
+	}).Methods(http.MethodGet)
+
+	r.Handle("/catalog/{id:[0-9]+}", &GetPartHandler{
+		Application: app,
+	}).Methods(http.MethodGet)
+
+	r.Handle("/catalog", &PostPartHandler{
+		Application: app,
+	}).Methods(http.MethodPost)
+
+	r.Handle("/catalog/{id:[0-9]+}", &PutPartHandler{
+		Application: app,
+	}).Methods(http.MethodPut)
+
+	r.Handle("/catalog/{id:[0-9]+}", &DeletePartHandler{
+		Application: app,
+	}).Methods(http.MethodDelete)
+
+	r.Handle("/cluster", &GetClusterHandler{
 		Application: app,
 	}).Methods(http.MethodGet)
 
-	r.Handle("/bikes/{id:[0-9]+}", &GetBikeHandler{
+	r.Handle("/dashboard", &DashboardHandler{
 		Application: app,
+		Template:    tmpl,
 	}).Methods(http.MethodGet)
 
-	r.Handle("/bikes", &PostBikeHandler{
+	r.Handle("/cluster/nodes/{id}", &PutNodeHandler{
+		Application: app,
+	}).Methods(http.MethodPut)
+
+	r.Handle("/cluster/leadership/transfer", &TransferLeadershipHandler{
+		Application: app,
+	}).Methods(http.MethodPost)
+
+	r.Handle("/cluster/snapshot", &SnapshotHandler{
+		Application: app,
+	}).Methods(http.MethodPost)
+
+	r.Handle("/cluster/join", &JoinHandler{
+		Application: app,
+	}).Methods(http.MethodPost)
+
+	r.Handle("/cluster/servers/{id}", &RemoveServerHandler{
+		Application: app,
+	}).Methods(http.MethodDelete)
+
+	r.Handle("/cluster/servers/{id}/promote", &PromoteServerHandler{
+		Application: app,
+	}).Methods(http.MethodPost)
+
+	r.Handle("/cluster/servers/{id}/demote", &DemoteServerHandler{
 		Application: app,
 	}).Methods(http.MethodPost)
 
@@ -188,20 +364,67 @@ func main() {
 		Handler: r,
 	}
 
+	if app.Config.APITLS != nil {
+		reloader, err := NewCertificateReloader(app.Config.APITLS.CertFile, app.Config.APITLS.KeyFile)
+		if err != nil {
+			log.Fatal(err)
+		}
+
+		reloaders = append(reloaders, reloader)
+
+		srv.TLSConfig, err = app.Config.APITLS.NewTLSConfig(reloader)
+		if err != nil {
+			log.Fatal(err)
+		}
+	}
+
+	app.Client = NewClient(srv.TLSConfig)
+
 	go func() {
 		log.Printf("Listening %d\n", app.Config.APIPort)
 
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+		var err error
+		if srv.TLSConfig != nil {
+			err = srv.ListenAndServeTLS("", "")
+		} else {
+			err = srv.ListenAndServe()
+		}
+
+		if err != nil && err != http.ErrServerClosed {
 			log.Fatal(err)
 		}
 	}()
 
-	quit := make(chan os.Signal)
-	signal.Notify(quit, os.Interrupt)
+	go func() {
+		hup := make(chan os.Signal, 1)
+		signal.Notify(hup, syscall.SIGHUP)
+
+		for range hup {
+			for _, reloader := range reloaders {
+				if err := reloader.Reload(); err != nil {
+					log.Printf("[RELOAD] cert_file=%s err=%s", reloader.CertFile, err)
+					continue
+				}
+
+				log.Printf("[RELOAD] cert_file=%s", reloader.CertFile)
+			}
+		}
+	}()
+
+	go app.PublishNode()
+
+	if !*Bootstrap && len(app.Config.Seeds) > 0 {
+		go app.JoinSeeds(transport.LocalAddr())
+	}
+
+	quit := make(chan os.Signal, 1)
+	signal.Notify(quit, os.Interrupt, syscall.SIGTERM)
 	<-quit
 
 	log.Print("Shutting down server")
 
+	app.Leave()
+
 	ctx, cancel := context.WithTimeout(context.Background(), parseDuration(app.Config.Graceful))
 	defer cancel()
 
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+var (
+	// ErrNoLeader is returned when a request must be forwarded while the cluster has no leader.
+	ErrNoLeader = errors.New("no leader")
+
+	// ErrUnknownLeaderAddress is returned when the leader has not published its API address yet.
+	ErrUnknownLeaderAddress = errors.New("unknown leader API address")
+)
+
+// Node is the metadata published by a member of the cluster, it is replicated through the Raft log.
+type Node struct {
+	ID         string `json:"id"`
+	APIAddress string `json:"api_address"`
+}
+
+func getNodes(q queryer, nodes *[]*Node) error {
+	rows, err := q.Query("SELECT id, api_address FROM node ORDER BY id")
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		node := Node{}
+
+		if err := rows.Scan(&node.ID, &node.APIAddress); err != nil {
+			return err
+		}
+
+		*nodes = append(*nodes, &node)
+	}
+
+	return rows.Err()
+}
+
+// GetNode gets the metadata of a node from database.
+func (bs *BikeStore) GetNode(id string, node *Node) error {
+	return bs.DB.QueryRow("SELECT id, api_address FROM node WHERE id = ?", id).Scan(&node.ID, &node.APIAddress)
+}
+
+// StoreNode inserts or replaces the metadata of a node.
+func (t *BikeTx) StoreNode(node *Node) error {
+	_, err := t.tx.Exec("INSERT OR REPLACE INTO node(id, api_address) VALUES(?, ?)", node.ID, node.APIAddress)
+	return err
+}
+
+// LeaderAPIAddress finds the API address published by the current leader.
+func (app *Application) LeaderAPIAddress() (string, error) {
+	leader := app.Cluster.Leader()
+	if leader == "" {
+		return "", ErrNoLeader
+	}
+
+	future := app.Cluster.GetConfiguration()
+	if err := future.Error(); err != nil {
+		return "", err
+	}
+
+	for _, server := range future.Configuration().Servers {
+		if server.Address != leader {
+			continue
+		}
+
+		node := Node{}
+		if err := app.BikeStore.GetNode(string(server.ID), &node); err != nil {
+			return "", ErrUnknownLeaderAddress
+		}
+
+		return node.APIAddress, nil
+	}
+
+	return "", ErrUnknownLeaderAddress
+}
+
+// PublishNode publishes the API address of this node until it is replicated, the leader applies
+// it directly while followers send it to the leader.
+func (app *Application) PublishNode() {
+	local := &Node{
+		ID:         app.Config.LocalID,
+		APIAddress: app.Config.APIAddress,
+	}
+
+	retry := parseDuration(app.Config.JoinRetry)
+
+	for {
+		node := Node{}
+		if err := app.BikeStore.GetNode(local.ID, &node); err == nil && node == *local {
+			log.Printf("[NODE] published api_address=%s", local.APIAddress)
+			return
+		}
+
+		if err := app.publishNode(local); err != nil {
+			log.Printf("[NODE] err=%s", err)
+		}
+
+		time.Sleep(retry)
+	}
+}
+
+func (app *Application) publishNode(node *Node) error {
+	if app.Cluster.State() == raft.Leader {
+		resp, err := app.Apply(context.Background(), SetNodeOp, node)
+		if err != nil {
+			return err
+		}
+
+		return resp.Err
+	}
+
+	body, err := json.Marshal(node)
+	if err != nil {
+		return err
+	}
+
+	return app.requestLeader(http.MethodPut, "/cluster/nodes/"+node.ID, body)
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+)
+
+// Role gives the permissions of a principal.
+type Role string
+
+const (
+	// ViewerRole can only read.
+	ViewerRole Role = "viewer"
+
+	// BuilderRole can also create bikes and edit the bikes it owns.
+	BuilderRole Role = "builder"
+
+	// AdminRole can do everything: edit any bike, delete bikes, manage the catalog and the cluster.
+	AdminRole Role = "admin"
+)
+
+// ErrForbidden is returned when the role of a principal does not allow an action.
+var ErrForbidden = errors.New("forbidden")
+
+var roleLevels = map[Role]int{
+	ViewerRole:  1,
+	BuilderRole: 2,
+	AdminRole:   3,
+}
+
+// Authorize checks that the principal of ctx has at least role, everything is allowed when authentication is disabled.
+//
+// Policies are only checked on the leader before a command is applied, so the FSM never evaluates them.
+func Authorize(ctx context.Context, role Role) error {
+	principal := PrincipalFrom(ctx)
+	if principal == nil {
+		return nil
+	}
+
+	if roleLevels[principal.Role] < roleLevels[role] {
+		return fmt.Errorf("%w: %s role required", ErrForbidden, role)
+	}
+
+	return nil
+}
+
+// AuthorizeBike checks that the bike belongs to the garage of ctx and that the principal of ctx is an admin or the builder owning it.
+func (app *Application) AuthorizeBike(ctx context.Context, id uint64) error {
+	if err := Authorize(ctx, BuilderRole); err != nil {
+		return err
+	}
+
+	if err := app.BikeStore.CheckGarage(GarageFrom(ctx), id); err != nil {
+		return err
+	}
+
+	principal := PrincipalFrom(ctx)
+	if principal == nil || principal.Role == AdminRole {
+		return nil
+	}
+
+	owner, err := app.BikeStore.GetOwner(id)
+	if err != nil {
+		return err
+	}
+
+	if owner != principal.Name {
+		return fmt.Errorf("%w: bike owned by another principal", ErrForbidden)
+	}
+
+	return nil
+}
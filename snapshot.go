@@ -1,8 +1,6 @@
 package main
 
 import (
-	"encoding/json"
-	"errors"
 	"log"
 
 	"github.com/hashicorp/raft"
@@ -11,91 +9,153 @@ import (
 // Limit is the batch size to select bikes from database.
 const Limit = 500
 
-// Snapshot is Raft snapshot.
+// Snapshot is Raft snapshot, it is a point-in-time view of the bike store.
 type Snapshot struct {
-	BikeStore *BikeStore
+	View        *BikeView
+	Counters    map[string]uint64
+	Compression SnapshotCompression
 }
 
-// SnapshotData gives access to the snapshot data.
-type SnapshotData struct {
-	Bike *Bike
-	Err  error
+// SnapshotRecord is an entry of a snapshot, only one of its fields is set. Counts is only set by
+// the last record of the snapshot format.
+type SnapshotRecord struct {
+	Counters map[string]uint64 `json:"counters,omitempty"`
+	Part     *Part             `json:"part,omitempty"`
+	Node     *Node             `json:"node,omitempty"`
+	Quota    *Garage           `json:"quota,omitempty"`
+	Bike     *Bike             `json:"bike,omitempty"`
+	Counts   map[string]uint64 `json:"counts,omitempty"`
+}
+
+// Kind names the field set in a record.
+func (r *SnapshotRecord) Kind() string {
+	switch {
+	case r.Counters != nil:
+		return "counters"
+	case r.Part != nil:
+		return "part"
+	case r.Node != nil:
+		return "node"
+	case r.Quota != nil:
+		return "quota"
+	case r.Bike != nil:
+		return "bike"
+	}
+
+	return ""
 }
 
 // NewSnapshot creates a snapshot.
-func NewSnapshot(bikeStore *BikeStore) (*Snapshot, error) {
+func NewSnapshot(view *BikeView, counters map[string]uint64, compression SnapshotCompression) (*Snapshot, error) {
 	return &Snapshot{
-		BikeStore: bikeStore,
+		View:        view,
+		Counters:    counters,
+		Compression: compression,
 	}, nil
 }
 
-// Persist persists a snapshot.
+// Persist persists a snapshot, the sink is cancelled when the snapshot cannot be completely written.
 func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
-	defer func() {
-		if err := sink.Close(); err != nil {
-			log.Fatal(err.Error())
-		}
-	}()
-
 	log.Printf("[PERSIST] sink=%#v", sink)
 
-	persisted := 0
+	if err := s.persist(sink); err != nil {
+		sink.Cancel()
+		return err
+	}
 
-	ch := make(chan *SnapshotData, 500)
-	errSnapshotFinished := errors.New("snapshot finished")
+	return sink.Close()
+}
 
-	go func() {
-		offset := uint64(0)
+func (s *Snapshot) persist(sink raft.SnapshotSink) error {
+	persisted := 0
 
-		for {
-			bikes := []*Bike{}
-			if err := s.BikeStore.GetBikes(Limit, offset, &bikes); err != nil {
-				ch <- &SnapshotData{
-					Err: err,
-				}
+	writer, err := NewSnapshotWriter(sink, s.Counters[AppliedIndexCounter], s.Compression)
+	if err != nil {
+		return err
+	}
 
-				break
-			}
+	if err := writer.Write(&SnapshotRecord{
+		Counters: s.Counters,
+	}); err != nil {
+		return err
+	}
 
-			for _, bike := range bikes {
-				ch <- &SnapshotData{
-					Bike: bike,
-				}
-			}
+	nodes := []*Node{}
+	if err := s.View.GetNodes(&nodes); err != nil {
+		return err
+	}
 
-			if len(bikes) < 500 {
-				ch <- &SnapshotData{
-					Err: errSnapshotFinished,
-				}
+	for _, node := range nodes {
+		if err := writer.Write(&SnapshotRecord{
+			Node: node,
+		}); err != nil {
+			return err
+		}
+	}
 
-				break
-			}
+	garages := []*Garage{}
+	if err := s.View.GetQuotas(&garages); err != nil {
+		return err
+	}
 
-			offset += 500
+	for _, garage := range garages {
+		if err := writer.Write(&SnapshotRecord{
+			Quota: garage,
+		}); err != nil {
+			return err
 		}
-	}()
+	}
+
+	after := uint64(0)
 
 	for {
-		snapshotData := <-ch
+		parts := []*Part{}
+		if err := s.View.GetPartsAfter(after, Limit, &parts); err != nil {
+			return err
+		}
 
-		if snapshotData.Err == errSnapshotFinished {
-			break
+		for _, part := range parts {
+			if err := writer.Write(&SnapshotRecord{
+				Part: part,
+			}); err != nil {
+				return err
+			}
+
+			after = part.ID
+			persisted++
 		}
 
-		if snapshotData.Err != nil {
-			return snapshotData.Err
+		if len(parts) < Limit {
+			break
 		}
+	}
 
-		data, err := json.Marshal(snapshotData.Bike)
-		if err != nil {
+	after = 0
+
+	for {
+		bikes := []*Bike{}
+		if err := s.View.GetBikesAfter("", after, Limit, &bikes); err != nil {
 			return err
 		}
 
-		if _, err := sink.Write(data); err != nil {
-			return err
+		for _, bike := range bikes {
+			if err := writer.Write(&SnapshotRecord{
+				Bike: bike,
+			}); err != nil {
+				return err
+			}
+
+			after = bike.ID
+			persisted++
 		}
 
-		persisted++
+		if len(bikes) < Limit {
+			break
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		return err
 	}
 
 	log.Printf("[PERSIST] persisted=%d", persisted)
@@ -103,7 +163,9 @@ func (s *Snapshot) Persist(sink raft.SnapshotSink) error {
 	return nil
 }
 
-// Release releases a snapshot.
+// Release releases a snapshot and its view.
 func (s *Snapshot) Release() {
 	log.Print("[RELEASE]")
+
+	s.View.Release()
 }
@@ -0,0 +1,367 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+
+	"github.com/klauspost/compress/zstd"
+)
+
+// Snapshot format.
+//
+// A snapshot starts with a 16 bytes header: the SnapshotMagic, the format version and flags as
+// big endian uint16 and the last applied Raft index as a big endian uint64. Records follow, each
+// one is a JSON SnapshotRecord prefixed by its length as a big endian uint32. The last record
+// only holds the number of records of each kind and is followed by the CRC-32 (IEEE) of every
+// previous uncompressed byte of the snapshot. The flags give the SnapshotCompression of
+// everything following the header.
+//
+// Snapshots written before this format are JSON values written back-to-back, they are recognized
+// because they do not start with the magic.
+const (
+	SnapshotMagic   = "BKSN"
+	SnapshotVersion = 1
+
+	snapshotHeaderSize = 16
+	maxSnapshotRecord  = 64 << 20
+)
+
+// SnapshotCompression is the codec of the records of a snapshot.
+type SnapshotCompression uint16
+
+// Snapshot compressions.
+const (
+	NoCompression SnapshotCompression = iota
+	GzipCompression
+	ZstdCompression
+)
+
+var snapshotCompressions = map[string]SnapshotCompression{
+	"none": NoCompression,
+	"gzip": GzipCompression,
+	"zstd": ZstdCompression,
+}
+
+var (
+	// ErrCorruptedSnapshot is returned when a snapshot does not match its header, counts or checksum.
+	ErrCorruptedSnapshot = errors.New("corrupted snapshot")
+
+	// ErrUnsupportedSnapshot is returned when a snapshot uses an unknown format version or flags.
+	ErrUnsupportedSnapshot = errors.New("unsupported snapshot")
+)
+
+// ParseSnapshotCompression parses the name of a compression, an empty name means no compression.
+func ParseSnapshotCompression(name string) (SnapshotCompression, error) {
+	if name == "" {
+		return NoCompression, nil
+	}
+
+	compression, ok := snapshotCompressions[name]
+	if !ok {
+		return NoCompression, fmt.Errorf("unknown snapshot compression %q", name)
+	}
+
+	return compression, nil
+}
+
+// String returns the name of a compression.
+func (c SnapshotCompression) String() string {
+	for name, compression := range snapshotCompressions {
+		if compression == c {
+			return name
+		}
+	}
+
+	return fmt.Sprintf("%#x", uint16(c))
+}
+
+// SnapshotWriter writes records in the snapshot format.
+type SnapshotWriter struct {
+	w          io.Writer
+	crc        hash.Hash32
+	counts     map[string]uint64
+	compressor io.WriteCloser
+}
+
+// NewSnapshotWriter writes the header of a snapshot, the records written next are compressed with the given compression.
+func NewSnapshotWriter(w io.Writer, appliedIndex uint64, compression SnapshotCompression) (*SnapshotWriter, error) {
+	sw := &SnapshotWriter{
+		crc:    crc32.NewIEEE(),
+		counts: map[string]uint64{},
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	copy(header, SnapshotMagic)
+	binary.BigEndian.PutUint16(header[4:], SnapshotVersion)
+	binary.BigEndian.PutUint16(header[6:], uint16(compression))
+	binary.BigEndian.PutUint64(header[8:], appliedIndex)
+
+	if _, err := io.MultiWriter(w, sw.crc).Write(header); err != nil {
+		return nil, err
+	}
+
+	switch compression {
+	case NoCompression:
+	case GzipCompression:
+		sw.compressor = gzip.NewWriter(w)
+	case ZstdCompression:
+		encoder, err := zstd.NewWriter(w, zstd.WithEncoderConcurrency(1))
+		if err != nil {
+			return nil, err
+		}
+
+		sw.compressor = encoder
+	default:
+		return nil, fmt.Errorf("%w: compression %s", ErrUnsupportedSnapshot, compression)
+	}
+
+	if sw.compressor != nil {
+		w = sw.compressor
+	}
+
+	sw.w = io.MultiWriter(w, sw.crc)
+
+	return sw, nil
+}
+
+// Write writes a record.
+func (sw *SnapshotWriter) Write(record *SnapshotRecord) error {
+	sw.counts[record.Kind()]++
+
+	return sw.writeRecord(record)
+}
+
+// Close writes the counts of records and the checksum, it does not close the underlying writer.
+func (sw *SnapshotWriter) Close() error {
+	if err := sw.writeRecord(&SnapshotRecord{
+		Counts: sw.counts,
+	}); err != nil {
+		return err
+	}
+
+	sum := make([]byte, 4)
+	binary.BigEndian.PutUint32(sum, sw.crc.Sum32())
+
+	if _, err := sw.w.Write(sum); err != nil {
+		return err
+	}
+
+	if sw.compressor != nil {
+		return sw.compressor.Close()
+	}
+
+	return nil
+}
+
+func (sw *SnapshotWriter) writeRecord(record *SnapshotRecord) error {
+	data, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+
+	size := make([]byte, 4)
+	binary.BigEndian.PutUint32(size, uint32(len(data)))
+
+	if _, err := sw.w.Write(size); err != nil {
+		return err
+	}
+
+	_, err = sw.w.Write(data)
+	return err
+}
+
+// SnapshotReader reads the records of a snapshot, in the snapshot format or in the legacy headerless format.
+type SnapshotReader struct {
+	Version      int
+	Compression  SnapshotCompression
+	AppliedIndex uint64
+	r            *bufio.Reader
+	crc          hash.Hash32
+	counts       map[string]uint64
+	decoder      *json.Decoder
+	decompressor io.Closer
+}
+
+// NewSnapshotReader reads the header of a snapshot, a snapshot without header is read as the legacy format with version 0.
+// The reader must be closed to release its decompressor.
+func NewSnapshotReader(r io.Reader) (*SnapshotReader, error) {
+	sr := &SnapshotReader{
+		r:      bufio.NewReader(r),
+		crc:    crc32.NewIEEE(),
+		counts: map[string]uint64{},
+	}
+
+	magic, err := sr.r.Peek(len(SnapshotMagic))
+	if err != nil && err != io.EOF {
+		return nil, err
+	}
+
+	if !bytes.Equal(magic, []byte(SnapshotMagic)) {
+		sr.decoder = json.NewDecoder(sr.r)
+		return sr, nil
+	}
+
+	header := make([]byte, snapshotHeaderSize)
+	if err := sr.readFull(header); err != nil {
+		return nil, err
+	}
+
+	sr.Version = int(binary.BigEndian.Uint16(header[4:]))
+	if sr.Version != SnapshotVersion {
+		return nil, fmt.Errorf("%w: version %d", ErrUnsupportedSnapshot, sr.Version)
+	}
+
+	sr.Compression = SnapshotCompression(binary.BigEndian.Uint16(header[6:]))
+	sr.AppliedIndex = binary.BigEndian.Uint64(header[8:])
+
+	switch sr.Compression {
+	case NoCompression:
+	case GzipCompression:
+		decompressor, err := gzip.NewReader(sr.r)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+		}
+
+		sr.r = bufio.NewReader(decompressor)
+		sr.decompressor = decompressor
+	case ZstdCompression:
+		decoder, err := zstd.NewReader(sr.r, zstd.WithDecoderConcurrency(1), zstd.WithDecoderLowmem(true))
+		if err != nil {
+			return nil, err
+		}
+
+		sr.r = bufio.NewReader(decoder)
+		sr.decompressor = decoder.IOReadCloser()
+	default:
+		return nil, fmt.Errorf("%w: compression %s", ErrUnsupportedSnapshot, sr.Compression)
+	}
+
+	return sr, nil
+}
+
+// Close releases the decompressor, it does not close the underlying reader.
+func (sr *SnapshotReader) Close() error {
+	if sr.decompressor != nil {
+		return sr.decompressor.Close()
+	}
+
+	return nil
+}
+
+// Read reads the next record, io.EOF is returned once the counts and the checksum are verified.
+func (sr *SnapshotReader) Read(record *SnapshotRecord) error {
+	if sr.decoder != nil {
+		return sr.readLegacy(record)
+	}
+
+	size := make([]byte, 4)
+	if err := sr.readFull(size); err != nil {
+		return err
+	}
+
+	length := binary.BigEndian.Uint32(size)
+	if length > maxSnapshotRecord {
+		return fmt.Errorf("%w: record of %d bytes", ErrCorruptedSnapshot, length)
+	}
+
+	data := make([]byte, length)
+	if err := sr.readFull(data); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, record); err != nil {
+		return fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+	}
+
+	if record.Counts == nil {
+		sr.counts[record.Kind()]++
+		return nil
+	}
+
+	return sr.verify(record.Counts)
+}
+
+func (sr *SnapshotReader) verify(counts map[string]uint64) error {
+	expected := sr.crc.Sum32()
+
+	sum := make([]byte, 4)
+	if _, err := io.ReadFull(sr.r, sum); err != nil {
+		return fmt.Errorf("%w: missing checksum", ErrCorruptedSnapshot)
+	}
+
+	if binary.BigEndian.Uint32(sum) != expected {
+		return fmt.Errorf("%w: checksum mismatch", ErrCorruptedSnapshot)
+	}
+
+	_, err := sr.r.ReadByte()
+	if err == nil {
+		return fmt.Errorf("%w: data after checksum", ErrCorruptedSnapshot)
+	}
+
+	if err != io.EOF {
+		return fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+	}
+
+	for kind, count := range counts {
+		if sr.counts[kind] != count {
+			return fmt.Errorf("%w: %d %s records read, %d expected", ErrCorruptedSnapshot, sr.counts[kind], kind, count)
+		}
+	}
+
+	for kind, count := range sr.counts {
+		if counts[kind] != count {
+			return fmt.Errorf("%w: %d %s records read, %d expected", ErrCorruptedSnapshot, count, kind, counts[kind])
+		}
+	}
+
+	return io.EOF
+}
+
+func (sr *SnapshotReader) readLegacy(record *SnapshotRecord) error {
+	if !sr.decoder.More() {
+		return io.EOF
+	}
+
+	data := json.RawMessage{}
+	if err := sr.decoder.Decode(&data); err != nil {
+		return err
+	}
+
+	if err := json.Unmarshal(data, record); err != nil {
+		return err
+	}
+
+	if record.Kind() != "" {
+		return nil
+	}
+
+	// Bikes are written as is in legacy snapshots.
+	record.Bike = &Bike{}
+	return json.Unmarshal(data, record.Bike)
+}
+
+func (sr *SnapshotReader) readFull(buf []byte) error {
+	if _, err := io.ReadFull(sr.r, buf); err != nil {
+		if err == io.EOF || err == io.ErrUnexpectedEOF {
+			return fmt.Errorf("%w: truncated", ErrCorruptedSnapshot)
+		}
+
+		if sr.decompressor != nil {
+			return fmt.Errorf("%w: %s", ErrCorruptedSnapshot, err)
+		}
+
+		return err
+	}
+
+	sr.crc.Write(buf)
+
+	return nil
+}
@@ -0,0 +1,311 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+)
+
+// testCompressions are the compressions every snapshot test runs with.
+var testCompressions = []SnapshotCompression{NoCompression, GzipCompression, ZstdCompression}
+
+// forEachCompression runs a test for every compression.
+func forEachCompression(t *testing.T, test func(t *testing.T, compression SnapshotCompression)) {
+	for _, compression := range testCompressions {
+		compression := compression
+
+		t.Run(compression.String(), func(t *testing.T) {
+			test(t, compression)
+		})
+	}
+}
+
+// testSink is a Raft snapshot sink writing to memory.
+type testSink struct {
+	bytes.Buffer
+}
+
+func (s *testSink) ID() string {
+	return "test"
+}
+
+func (s *testSink) Cancel() error {
+	return nil
+}
+
+func (s *testSink) Close() error {
+	return nil
+}
+
+func newTestBikeStore(t *testing.T) *BikeStore {
+	t.Helper()
+
+	bs, err := NewBikeStore(filepath.Join(t.TempDir(), "bikes.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		bs.DB.Close()
+	})
+
+	return bs
+}
+
+// writeTestSnapshot writes a snapshot of counters, a part and bikes.
+func writeTestSnapshot(t *testing.T, compression SnapshotCompression, bikes int) []byte {
+	t.Helper()
+
+	buf := &bytes.Buffer{}
+
+	writer, err := NewSnapshotWriter(buf, 42, compression)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	records := []*SnapshotRecord{
+		{Counters: map[string]uint64{AppliedIndexCounter: 42, BikeCounter: uint64(bikes), CatalogCounter: 1}},
+		{Part: &Part{ID: 1, Name: "frame", Category: "frame"}},
+	}
+
+	for i := 1; i <= bikes; i++ {
+		records = append(records, &SnapshotRecord{Bike: &Bike{ID: uint64(i), Name: "bike", Garage: DefaultGarage}})
+	}
+
+	for _, record := range records {
+		if err := writer.Write(record); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+// readTestSnapshot reads every record of a snapshot.
+func readTestSnapshot(data []byte) (*SnapshotReader, []*SnapshotRecord, error) {
+	reader, err := NewSnapshotReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, err
+	}
+	defer reader.Close()
+
+	records := []*SnapshotRecord{}
+	for {
+		record := SnapshotRecord{}
+		if err := reader.Read(&record); err != nil {
+			if err == io.EOF {
+				return reader, records, nil
+			}
+
+			return reader, records, err
+		}
+
+		records = append(records, &record)
+	}
+}
+
+func TestSnapshotRoundTrip(t *testing.T) {
+	forEachCompression(t, func(t *testing.T, compression SnapshotCompression) {
+		reader, records, err := readTestSnapshot(writeTestSnapshot(t, compression, 3))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if reader.Version != SnapshotVersion || reader.Compression != compression || reader.AppliedIndex != 42 {
+			t.Fatalf("version=%d compression=%s applied_index=%d, want %d, %s and 42", reader.Version, reader.Compression, reader.AppliedIndex, SnapshotVersion, compression)
+		}
+
+		kinds := []string{}
+		for _, record := range records {
+			kinds = append(kinds, record.Kind())
+		}
+
+		want := []string{"counters", "part", "bike", "bike", "bike"}
+		if len(kinds) != len(want) {
+			t.Fatalf("read %v, want %v", kinds, want)
+		}
+
+		for i := range want {
+			if kinds[i] != want[i] {
+				t.Fatalf("read %v, want %v", kinds, want)
+			}
+		}
+	})
+}
+
+func TestSnapshotCorruption(t *testing.T) {
+	forEachCompression(t, func(t *testing.T, compression SnapshotCompression) {
+		data := writeTestSnapshot(t, compression, 20)
+
+		flipped := append([]byte{}, data...)
+		flipped[len(flipped)/2] ^= 0xff
+
+		extra := append(append([]byte{}, data...), 0)
+
+		for name, data := range map[string][]byte{
+			"flipped byte":   flipped,
+			"truncated":      data[:len(data)-10],
+			"no checksum":    data[:len(data)-4],
+			"data after end": extra,
+		} {
+			if _, _, err := readTestSnapshot(data); !errors.Is(err, ErrCorruptedSnapshot) {
+				t.Errorf("%s: err=%v, want %v", name, err, ErrCorruptedSnapshot)
+			}
+		}
+	})
+}
+
+func TestSnapshotWrongCount(t *testing.T) {
+	forEachCompression(t, func(t *testing.T, compression SnapshotCompression) {
+		buf := &bytes.Buffer{}
+
+		writer, err := NewSnapshotWriter(buf, 42, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := writer.Write(&SnapshotRecord{Bike: &Bike{ID: 1, Name: "bike"}}); err != nil {
+			t.Fatal(err)
+		}
+
+		// The checksum is valid but the counts do not match the records.
+		writer.counts["bike"]++
+
+		if err := writer.Close(); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, _, err := readTestSnapshot(buf.Bytes()); !errors.Is(err, ErrCorruptedSnapshot) {
+			t.Fatalf("err=%v, want %v", err, ErrCorruptedSnapshot)
+		}
+	})
+}
+
+func TestSnapshotUnknownVersion(t *testing.T) {
+	forEachCompression(t, func(t *testing.T, compression SnapshotCompression) {
+		data := writeTestSnapshot(t, compression, 1)
+		binary.BigEndian.PutUint16(data[4:], SnapshotVersion+1)
+
+		if _, _, err := readTestSnapshot(data); !errors.Is(err, ErrUnsupportedSnapshot) {
+			t.Fatalf("err=%v, want %v", err, ErrUnsupportedSnapshot)
+		}
+	})
+}
+
+func TestSnapshotUnknownCompression(t *testing.T) {
+	data := writeTestSnapshot(t, NoCompression, 1)
+	binary.BigEndian.PutUint16(data[6:], 0xff)
+
+	if _, _, err := readTestSnapshot(data); !errors.Is(err, ErrUnsupportedSnapshot) {
+		t.Fatalf("err=%v, want %v", err, ErrUnsupportedSnapshot)
+	}
+}
+
+func TestSnapshotLegacy(t *testing.T) {
+	data := []byte(`{"counters":{"applied_index":7,"bike":2}}{"part":{"id":1,"name":"frame"}}{"id":1,"name":"a","components":null}{"id":2,"name":"b","components":null}`)
+
+	reader, records, err := readTestSnapshot(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if reader.Version != 0 {
+		t.Fatalf("version=%d, want 0", reader.Version)
+	}
+
+	if len(records) != 4 || records[2].Bike == nil || records[3].Bike.Name != "b" {
+		t.Fatalf("unexpected records %+v", records)
+	}
+}
+
+func TestRestoreKeepsStateOnCorruption(t *testing.T) {
+	forEachCompression(t, func(t *testing.T, compression SnapshotCompression) {
+		bs := newTestBikeStore(t)
+
+		if err := bs.Update(5, func(tx *BikeTx) error {
+			return tx.StoreBikes([]*Bike{{Name: "kept"}})
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		fsm, err := NewFSM(bs, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		data := writeTestSnapshot(t, compression, 20)
+		data[len(data)/2] ^= 0xff
+
+		if err := fsm.Restore(io.NopCloser(bytes.NewReader(data))); !errors.Is(err, ErrCorruptedSnapshot) {
+			t.Fatalf("err=%v, want %v", err, ErrCorruptedSnapshot)
+		}
+
+		bike := Bike{}
+		if err := bs.GetBike(1, &bike); err != nil || bike.Name != "kept" {
+			t.Fatalf("bike=%+v err=%v, want the bike stored before the restore", bike, err)
+		}
+
+		if index := fsm.AppliedIndex.Load(); index != 5 {
+			t.Fatalf("applied index=%d, want 5", index)
+		}
+	})
+}
+
+func TestSnapshotRestore(t *testing.T) {
+	forEachCompression(t, func(t *testing.T, compression SnapshotCompression) {
+		source := newTestBikeStore(t)
+
+		// More than two batches of bikes are restored.
+		stored := []*Bike{}
+		for i := 0; i < 2*Limit+1; i++ {
+			stored = append(stored, &Bike{Name: "bike"})
+		}
+
+		if err := source.Update(9, func(tx *BikeTx) error {
+			return tx.StoreBikes(stored)
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		fsm, err := NewFSM(source, compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		snapshot, err := fsm.Snapshot()
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer snapshot.Release()
+
+		sink := &testSink{}
+		if err := snapshot.Persist(sink); err != nil {
+			t.Fatal(err)
+		}
+
+		target, err := NewFSM(newTestBikeStore(t), compression)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if err := target.Restore(io.NopCloser(sink)); err != nil {
+			t.Fatal(err)
+		}
+
+		bikes := []*Bike{}
+		if err := target.BikeStore.GetBikes("", 3*Limit, 0, &bikes); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(bikes) != len(stored) || target.AppliedIndex.Load() != 9 {
+			t.Fatalf("restored %d bikes at index %d, want %d at index 9", len(bikes), target.AppliedIndex.Load(), len(stored))
+		}
+	})
+}
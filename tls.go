@@ -0,0 +1,163 @@
+package main
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// TLSConfiguration gives the certificates used by a node, CA signs the certificates of the other nodes.
+type TLSConfiguration struct {
+	CertFile          string `json:"cert_file"`
+	KeyFile           string `json:"key_file"`
+	CAFile            string `json:"ca_file"`
+	RequireClientCert bool   `json:"require_client_cert"`
+}
+
+// CertificateReloader holds a certificate which can be reloaded from its files while it is in use.
+type CertificateReloader struct {
+	CertFile string
+	KeyFile  string
+	mu       sync.RWMutex
+	cert     *tls.Certificate
+}
+
+// NewCertificateReloader loads a certificate.
+func NewCertificateReloader(certFile, keyFile string) (*CertificateReloader, error) {
+	reloader := &CertificateReloader{
+		CertFile: certFile,
+		KeyFile:  keyFile,
+	}
+
+	if err := reloader.Reload(); err != nil {
+		return nil, err
+	}
+
+	return reloader, nil
+}
+
+// Reload reads the certificate files again, the previous certificate is kept when they are invalid.
+func (r *CertificateReloader) Reload() error {
+	cert, err := tls.LoadX509KeyPair(r.CertFile, r.KeyFile)
+	if err != nil {
+		return err
+	}
+
+	r.mu.Lock()
+	r.cert = &cert
+	r.mu.Unlock()
+
+	return nil
+}
+
+// GetCertificate returns the certificate presented by servers.
+func (r *CertificateReloader) GetCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// GetClientCertificate returns the certificate presented by clients.
+func (r *CertificateReloader) GetClientCertificate(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	return r.cert, nil
+}
+
+// NewTLSConfig creates a TLS configuration usable by both servers and clients, its certificate is given by reloader.
+// Servers verify the client certificates against ca_file when given, and require one with require_client_cert.
+func (c *TLSConfiguration) NewTLSConfig(reloader *CertificateReloader) (*tls.Config, error) {
+	config := &tls.Config{
+		GetCertificate:       reloader.GetCertificate,
+		GetClientCertificate: reloader.GetClientCertificate,
+		MinVersion:           tls.VersionTLS12,
+	}
+
+	if c.CAFile != "" {
+		pem, err := os.ReadFile(c.CAFile)
+		if err != nil {
+			return nil, err
+		}
+
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(pem) {
+			return nil, errors.New("no certificate found in CA file")
+		}
+
+		config.RootCAs = pool
+		config.ClientCAs = pool
+		config.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+
+	if c.RequireClientCert {
+		config.ClientAuth = tls.RequireAndVerifyClientCert
+	}
+
+	return config, nil
+}
+
+// TLSStreamLayer is a Raft stream layer encrypting the traffic between nodes with TLS.
+type TLSStreamLayer struct {
+	listener  net.Listener
+	advertise net.Addr
+	config    *tls.Config
+}
+
+// NewTLSStreamLayer listens on bindAddr, connections are accepted and dialed with config. Whatever
+// require_client_cert, only peers presenting a certificate signed by the CA of config are accepted so
+// that no one else can send Raft RPCs, config must therefore have a CA.
+func NewTLSStreamLayer(bindAddr string, advertise net.Addr, config *tls.Config) (*TLSStreamLayer, error) {
+	if config.ClientCAs == nil || config.RootCAs == nil {
+		return nil, errors.New("raft_tls requires ca_file")
+	}
+
+	config = config.Clone()
+	config.ClientAuth = tls.RequireAndVerifyClientCert
+
+	listener, err := tls.Listen("tcp", bindAddr, config)
+	if err != nil {
+		return nil, err
+	}
+
+	return &TLSStreamLayer{
+		listener:  listener,
+		advertise: advertise,
+		config:    config,
+	}, nil
+}
+
+// Dial opens a TLS connection to another node.
+func (s *TLSStreamLayer) Dial(address raft.ServerAddress, timeout time.Duration) (net.Conn, error) {
+	dialer := &net.Dialer{
+		Timeout: timeout,
+	}
+
+	return tls.DialWithDialer(dialer, "tcp", string(address), s.config)
+}
+
+// Accept waits for the next connection, its TLS handshake happens on the first read so a slow peer does not block the others.
+func (s *TLSStreamLayer) Accept() (net.Conn, error) {
+	return s.listener.Accept()
+}
+
+// Close closes the listener.
+func (s *TLSStreamLayer) Close() error {
+	return s.listener.Close()
+}
+
+// Addr returns the advertised address, the listener address is used when it is not set.
+func (s *TLSStreamLayer) Addr() net.Addr {
+	if s.advertise != nil {
+		return s.advertise
+	}
+
+	return s.listener.Addr()
+}
@@ -0,0 +1,276 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/raft"
+)
+
+// testCA is a certificate authority generated for a test.
+type testCA struct {
+	dir  string
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	file string
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageDigitalSignature,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ca := &testCA{
+		dir:  t.TempDir(),
+		cert: cert,
+		key:  key,
+	}
+
+	ca.file = ca.writePEM(t, name+".pem", "CERTIFICATE", der)
+
+	return ca
+}
+
+// issue signs a certificate valid for 127.0.0.1 and returns its TLS configuration.
+func (ca *testCA) issue(t *testing.T, name string, requireClientCert bool) *TLSConfiguration {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+
+	der, err := x509.CreateCertificate(rand.Reader, template, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return &TLSConfiguration{
+		CertFile:          ca.writePEM(t, name+".pem", "CERTIFICATE", der),
+		KeyFile:           ca.writePEM(t, name+".key", "EC PRIVATE KEY", keyDER),
+		CAFile:            ca.file,
+		RequireClientCert: requireClientCert,
+	}
+}
+
+func (ca *testCA) writePEM(t *testing.T, name, blockType string, der []byte) string {
+	t.Helper()
+
+	file := filepath.Join(ca.dir, name)
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: blockType, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	return file
+}
+
+func newTestStreamLayer(t *testing.T, c *TLSConfiguration) *TLSStreamLayer {
+	t.Helper()
+
+	reloader, err := NewCertificateReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := c.NewTLSConfig(reloader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stream, err := NewTLSStreamLayer("127.0.0.1:0", nil, config)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Cleanup(func() {
+		stream.Close()
+	})
+
+	return stream
+}
+
+// accept accepts a connection, completes its handshake and echoes what it reads.
+func accept(stream *TLSStreamLayer) <-chan error {
+	done := make(chan error, 1)
+
+	go func() {
+		conn, err := stream.Accept()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+
+		if err := conn.(*tls.Conn).Handshake(); err != nil {
+			done <- err
+			return
+		}
+
+		buf := make([]byte, 4)
+		if _, err := io.ReadFull(conn, buf); err != nil {
+			done <- err
+			return
+		}
+
+		_, err = conn.Write(buf)
+		done <- err
+	}()
+
+	return done
+}
+
+func TestTLSStreamLayer(t *testing.T) {
+	ca := newTestCA(t, "ca")
+
+	server := newTestStreamLayer(t, ca.issue(t, "node1", true))
+	client := newTestStreamLayer(t, ca.issue(t, "node2", true))
+
+	done := accept(server)
+
+	conn, err := client.Dial(raft.ServerAddress(server.Addr().String()), time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	if _, err := conn.Write([]byte("ping")); err != nil {
+		t.Fatal(err)
+	}
+
+	buf := make([]byte, 4)
+	if _, err := io.ReadFull(conn, buf); err != nil {
+		t.Fatal(err)
+	}
+
+	if string(buf) != "ping" {
+		t.Fatalf("read %q, want %q", buf, "ping")
+	}
+
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// expectRejected checks that a client connection is refused by the server.
+func expectRejected(t *testing.T, conn net.Conn, err error, done <-chan error) {
+	t.Helper()
+
+	if err == nil {
+		defer conn.Close()
+
+		// With TLS 1.3 the client learns that its certificate is rejected on its first read.
+		conn.SetDeadline(time.Now().Add(time.Second))
+		conn.Write([]byte("ping"))
+
+		if _, err := io.ReadFull(conn, make([]byte, 4)); err == nil {
+			t.Fatal("rejected client exchanged data")
+		}
+	}
+
+	if err := <-done; err == nil {
+		t.Fatal("server accepted the connection")
+	}
+}
+
+func TestTLSStreamLayerRejectsOtherCA(t *testing.T) {
+	for _, requireClientCert := range []bool{true, false} {
+		ca := newTestCA(t, "ca")
+		rogueCA := newTestCA(t, "rogue-ca")
+
+		server := newTestStreamLayer(t, ca.issue(t, "node1", requireClientCert))
+
+		// The rogue node trusts the server but its own certificate is signed by another CA.
+		rogue := rogueCA.issue(t, "rogue", true)
+		rogue.CAFile = ca.file
+
+		client := newTestStreamLayer(t, rogue)
+
+		done := accept(server)
+
+		conn, err := client.Dial(raft.ServerAddress(server.Addr().String()), time.Second)
+		expectRejected(t, conn, err, done)
+	}
+}
+
+func TestTLSStreamLayerRejectsNoCertificate(t *testing.T) {
+	ca := newTestCA(t, "ca")
+
+	// A client certificate is required even without require_client_cert.
+	server := newTestStreamLayer(t, ca.issue(t, "node1", false))
+
+	done := accept(server)
+
+	conn, err := tls.Dial("tcp", server.Addr().String(), &tls.Config{
+		InsecureSkipVerify: true,
+	})
+	expectRejected(t, conn, err, done)
+}
+
+func TestTLSStreamLayerRequiresCA(t *testing.T) {
+	c := newTestCA(t, "ca").issue(t, "node1", false)
+	c.CAFile = ""
+
+	reloader, err := NewCertificateReloader(c.CertFile, c.KeyFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	config, err := c.NewTLSConfig(reloader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := NewTLSStreamLayer("127.0.0.1:0", nil, config); err == nil {
+		t.Fatal("stream layer created without CA")
+	}
+}